@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/shikidalab/anonymize-ecg/controller"
+	"github.com/shikidalab/anonymize-ecg/model"
+)
+
+// runAnonymize は anonymize サブコマンドを実行する
+// ブラウザを使わずに、ディレクトリ内の心電図をまとめて匿名化する
+func runAnonymize(args []string) error {
+	s := newSettings("anonymize", "anonymize -in DIR -out DIR|FILE.zip [flags]")
+	in := s.flags.String("in", "", "directory containing the files to anonymize (read recursively)")
+	out := s.flags.String("out", "", "output directory, or ZIP file if it ends with .zip")
+	secret := s.secret("secret", "ANONYMIZE_SECRET", "password used for anonymization")
+	formats := s.flags.String("formats", "", "comma-separated extra output formats for mwf files (wfdb, edf)")
+	s.anonymizeFlags()
+	if err := s.parse(args); err != nil {
+		return err
+	}
+
+	if *in == "" || *out == "" {
+		s.flags.Usage()
+		return errors.New("-in and -out are required")
+	}
+	password := *secret
+	if password == "" {
+		return errors.New("-secret or ANONYMIZE_SECRET is required")
+	}
+	if err := model.SetupDB(os.Getenv("DSN")); err != nil {
+		return err
+	}
+
+	var outputFormats []string
+	for _, format := range strings.Split(*formats, ",") {
+		if format = strings.TrimSpace(format); format != "" {
+			outputFormats = append(outputFormats, format)
+		}
+	}
+
+	summary, err := controller.AnonymizeDir(*in, password, *out, outputFormats)
+	fmt.Printf("anonymized: %d, skipped: %d, failed: %d, output files: %d\n",
+		summary.Anonymized, summary.Skipped, summary.Failed, summary.Outputs)
+	return err
+}
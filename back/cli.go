@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command はサブコマンド
+type command struct {
+	name    string // "db migrate" のように空白で区切ったコマンド名
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "start the HTTP server for the web UI (default)", runServe},
+	{"anonymize", "anonymize mwf/xml files in a directory", runAnonymize},
+	{"export", "save the patient ID / pseudonym table as CSV", runExport},
+	{"inspect", "print the header of mwf or xml files", runInspect},
+	{"convert", "convert anonymized xml files to mwf and mwf files to xml", runConvert},
+	{"compare", "check that the waveforms of an xml file and an mwf file match", runCompare},
+	{"watch", "anonymize mwf/xml files as they arrive in a directory", runWatch},
+	{"verify", "check that anonymized files contain no identifiers of the originals", runVerify},
+	{"db migrate", "create or update the database tables", runDBMigrate},
+	{"db backup", "copy the database to a file", runDBBackup},
+}
+
+// findCommand は引数の先頭からサブコマンドを探し、残りの引数とともに返す
+func findCommand(args []string) (*command, []string) {
+	for i := range commands {
+		words := strings.Fields(commands[i].name)
+		if len(args) < len(words) {
+			continue
+		}
+		matched := true
+		for n, word := range words {
+			if args[n] != word {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return &commands[i], args[len(words):]
+		}
+	}
+	return nil, args
+}
+
+func printCommands(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [global flags] <command> [flags]\n\nCommands:\n", os.Args[0])
+	names := make([]string, len(commands))
+	summaries := make(map[string]string)
+	for i, c := range commands {
+		names[i] = c.name
+		summaries[c.name] = c.summary
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-12s %s\n", name, summaries[name])
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of each command.\n", os.Args[0])
+}
+
+// settings はフラグと環境変数の対応を持つ
+// フラグの既定値は環境変数の値で、指定されたフラグの値は環境変数に書き戻す
+// (controller などは環境変数から設定を読むため)
+type settings struct {
+	flags   *flag.FlagSet
+	envs    map[string]string
+	secrets map[string]*string // 値をヘルプに表示しないフラグ
+}
+
+func newSettings(name, usage string) *settings {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s\n\n", os.Args[0], usage)
+		flags.PrintDefaults()
+	}
+	return &settings{flags: flags, envs: make(map[string]string), secrets: make(map[string]*string)}
+}
+
+// env は環境変数 env を既定値とするフラグを定義する (値はヘルプに表示される)
+func (s *settings) env(name, env, usage string) *string {
+	s.envs[name] = env
+	return s.flags.String(name, os.Getenv(env), fmt.Sprintf("%s (env %s)", usage, env))
+}
+
+// secret はパスワードなどを含みうる設定のフラグを定義する
+// 既定値はヘルプに表示されるため、環境変数の値は既定値にせず parse で読む
+func (s *settings) secret(name, env, usage string) *string {
+	s.envs[name] = env
+	value := s.flags.String(name, "", fmt.Sprintf("%s (default: $%s)", usage, env))
+	s.secrets[name] = value
+	return value
+}
+
+// parse は引数を読み、指定されたフラグの値を環境変数に書き戻す
+func (s *settings) parse(args []string) error {
+	if err := s.flags.Parse(args); err != nil {
+		return err
+	}
+	var err error
+	visited := make(map[string]bool)
+	s.flags.Visit(func(f *flag.Flag) {
+		visited[f.Name] = true
+		if env, ok := s.envs[f.Name]; ok && err == nil {
+			err = os.Setenv(env, f.Value.String())
+		}
+	})
+	for name, value := range s.secrets {
+		if !visited[name] {
+			*value = os.Getenv(s.envs[name])
+		}
+	}
+	return err
+}
+
+// anonymizeFlags は匿名化の設定のフラグを定義する
+func (s *settings) anonymizeFlags() {
+	s.secret("dsn", "DSN", "SQLite database file")
+	s.env("mwf-pseudonymize", "MWF_PSEUDONYMIZE", `"true" to replace the mwf patient ID with the pseudonym`)
+	s.env("mwf-text-actions", "MWF_TEXT_ACTIONS", `actions for mwf free-text tags, e.g. "COMMENT=keep,UID=delete"`)
+	s.env("xml-policy", "XML_POLICY", "YAML or JSON file of xml anonymization rules")
+	s.env("pseudonym-scheme", "PSEUDONYM_SCHEME", `"hmac-v1" (default), or "legacy" to reproduce pseudonyms made by older versions`)
+}
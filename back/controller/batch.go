@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AnonymizeDir は dir 以下のファイルを再帰的に読み、Webからのアップロードと同じ手順で匿名化して output に書く
+// output が .zip で終わる場合は ZIP ファイルに、それ以外はディレクトリに書く
+// mwf の匿名化には xml から登録した患者の対応表を使うため、xml をすべて処理してから mwf を処理する
+func AnonymizeDir(dir, password, output string, outputFormats []string) (Summary, error) {
+	var summary Summary
+	for _, format := range outputFormats {
+		if !isOutputFormat(format) {
+			return summary, fmt.Errorf("error output format %q: %w", format, errOutputFormat)
+		}
+	}
+
+	var xmlFiles, mwfFiles []File
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fileType := getFileType(path)
+		if fileType == "" {
+			summary.Skipped++
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		file := File{Name: d.Name(), Content: content}
+		if fileType == ".xml" {
+			xmlFiles = append(xmlFiles, file)
+		} else {
+			mwfFiles = append(mwfFiles, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return summary, fmt.Errorf("error reading %s: %w", dir, err)
+	}
+
+	write, closeOutput, err := openOutput(output)
+	if err != nil {
+		return summary, err
+	}
+
+	var batchErr error
+	for _, files := range [][]File{xmlFiles, mwfFiles} {
+		if len(files) == 0 {
+			continue
+		}
+		anonymizedFiles, result, err := processFiles(files, password, outputFormats)
+		summary.add(result)
+		if err != nil {
+			log.Println("Error processing files:", err)
+			batchErr = err
+			continue
+		}
+		if err := write(anonymizedFiles); err != nil {
+			closeOutput()
+			return summary, err
+		}
+	}
+
+	if err := closeOutput(); err != nil {
+		return summary, err
+	}
+	return summary, batchErr
+}
+
+// openOutput は出力先に応じてファイルを書く関数と、出力先を閉じる関数を返す
+func openOutput(output string) (func([]File) error, func() error, error) {
+	if strings.EqualFold(filepath.Ext(output), ".zip") {
+		if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+			return nil, nil, err
+		}
+		f, err := os.Create(output)
+		if err != nil {
+			return nil, nil, err
+		}
+		zipWriter := zip.NewWriter(f)
+		write := func(files []File) error {
+			return addFilesToZip(zipWriter, files)
+		}
+		closeOutput := func() error {
+			if err := zipWriter.Close(); err != nil {
+				f.Close()
+				return fmt.Errorf("%s: %v", errZipCreation, err)
+			}
+			return f.Close()
+		}
+		return write, closeOutput, nil
+	}
+
+	if err := os.MkdirAll(output, 0o755); err != nil {
+		return nil, nil, err
+	}
+	write := func(files []File) error {
+		for _, file := range files {
+			if err := os.WriteFile(filepath.Join(output, file.Name), file.Content, 0o644); err != nil {
+				return fmt.Errorf("%s: %v", errFileWrite, err)
+			}
+		}
+		return nil
+	}
+	return write, func() error { return nil }, nil
+}
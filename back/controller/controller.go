@@ -3,8 +3,9 @@ package controller
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/hmac"
 	"crypto/sha256"
-	"encoding/hex"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -20,6 +21,7 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/shikidalab/anonymize-ecg/mfer"
 	"github.com/shikidalab/anonymize-ecg/model"
+	"github.com/shikidalab/anonymize-ecg/verify"
 	"github.com/shikidalab/anonymize-ecg/xml"
 )
 
@@ -37,6 +39,8 @@ var (
 	errFileNameFormat   = errors.New("file name format is incorrect")
 	errZipCreation      = errors.New("failed to create ZIP file")
 	errFileWrite        = errors.New("failed to write file")
+	errOutputFormat     = errors.New("unsupported output format")
+	errNoPatientID      = errors.New("patient ID not found")
 )
 
 type File struct {
@@ -58,7 +62,7 @@ func AnonymizeECG(c *gin.Context) {
 	}
 	defer conn.Close()
 
-	password, err := validatePassword(conn)
+	creds, err := validatePassword(conn)
 	if err != nil {
 		c.String(http.StatusUnauthorized, err.Error())
 		log.Println("Error in validate password: ", err)
@@ -84,13 +88,17 @@ func AnonymizeECG(c *gin.Context) {
 	zipBuffer := new(bytes.Buffer)
 	zipWriter := zip.NewWriter(zipBuffer)
 
+	// 識別子が残っていたため出力しなかったファイルの位置 (最後にクライアントへ送る)
+	var findings []verify.Finding
+
 	// XMLファイルの処理を完了してからMWFファイルを処理
 	go func() {
 		// まずXMLファイルを処理
 		for xmlFiles := range xmlCh {
-			anonymizedFiles, err := processFiles(xmlFiles, password)
+			anonymizedFiles, _, err := processFiles(xmlFiles, creds.Password, creds.OutputFormats)
 			if err != nil {
 				log.Println("Error processing XML files:", err)
+				findings = append(findings, residualFindings(err)...)
 				continue
 			}
 			if err := addFilesToZip(zipWriter, anonymizedFiles); err != nil {
@@ -100,9 +108,10 @@ func AnonymizeECG(c *gin.Context) {
 
 		// 次にMWFファイルを処理
 		for mwfFiles := range mwfCh {
-			anonymizedFiles, err := processFiles(mwfFiles, password)
+			anonymizedFiles, _, err := processFiles(mwfFiles, creds.Password, creds.OutputFormats)
 			if err != nil {
 				log.Println("Error processing MWF files:", err)
+				findings = append(findings, residualFindings(err)...)
 				continue
 			}
 			if err := addFilesToZip(zipWriter, anonymizedFiles); err != nil {
@@ -117,10 +126,19 @@ func AnonymizeECG(c *gin.Context) {
 	// 処理完了を待機
 	<-doneCh
 
-	sendZipResponse(c, zipBuffer, conn)
+	sendZipResponse(c, zipBuffer, conn, findings)
 	log.Println("The files have been anonymized")
 }
 
+// residualFindings は出力に識別子が残っていたことによるエラーの場合に、残っていた位置を返す
+func residualFindings(err error) []verify.Finding {
+	var residual *verify.ResidualPIIError
+	if errors.As(err, &residual) {
+		return residual.Findings
+	}
+	return nil
+}
+
 func receiveAndBufferFiles(conn *websocket.Conn, xmlCh, mwfCh chan<- []File) {
 	ch := make(chan []File)
 	go receiveMessage(conn, ch)
@@ -164,29 +182,39 @@ func addFilesToZip(zipWriter *zip.Writer, files []File) error {
 	return nil
 }
 
-func validatePassword(conn *websocket.Conn) (string, error) {
+// credentials はフロントエンドから最初に送られる認証情報と出力形式
+type credentials struct {
+	Type                 string   `json:"type"`
+	Password             string   `json:"password"`
+	PasswordConfirmation string   `json:"passwordConfirmation"`
+	OutputFormats        []string `json:"outputFormats"` // mwf に加えて出力する形式 (wfdb, edf)
+}
+
+func validatePassword(conn *websocket.Conn) (credentials, error) {
 	messageType, msg, err := conn.ReadMessage()
 	if err != nil {
-		return "", fmt.Errorf("error reading message: %w", err)
+		return credentials{}, fmt.Errorf("error reading message: %w", err)
 	}
 
-	var creds struct {
-		Type                 string `json:"type"`
-		Password             string `json:"password"`
-		PasswordConfirmation string `json:"passwordConfirmation"`
-	}
+	var creds credentials
 
 	if messageType == websocket.TextMessage {
 		err := json.Unmarshal(msg, &creds)
 		if err != nil {
-			return "", fmt.Errorf("error json.Unmershal: %w", err)
+			return credentials{}, fmt.Errorf("error json.Unmershal: %w", err)
 		}
 
 		if creds.Password != creds.PasswordConfirmation {
-			return "", fmt.Errorf("error mathing password: %w", errPasswordMismatch)
+			return credentials{}, fmt.Errorf("error mathing password: %w", errPasswordMismatch)
+		}
+
+		for _, format := range creds.OutputFormats {
+			if !isOutputFormat(format) {
+				return credentials{}, fmt.Errorf("error output format %q: %w", format, errOutputFormat)
+			}
 		}
 	}
-	return creds.Password, nil
+	return creds, nil
 }
 
 func receiveMessage(conn *websocket.Conn, ch chan []File) {
@@ -239,76 +267,258 @@ func receiveMessage(conn *websocket.Conn, ch chan []File) {
 	close(ch)
 }
 
-func processFiles(files []File, password string) ([]File, error) {
+// Summary は匿名化したファイルの数
+type Summary struct {
+	Anonymized int // 匿名化したファイル
+	Skipped    int // mwf と xml 以外のため処理しなかったファイル
+	Failed     int // 匿名化できなかったファイル
+	Outputs    int // 出力したファイル (変換したファイルを含む)
+}
+
+func (s *Summary) add(other Summary) {
+	s.Anonymized += other.Anonymized
+	s.Skipped += other.Skipped
+	s.Failed += other.Failed
+	s.Outputs += other.Outputs
+}
+
+// processFiles はファイルをまとめて匿名化し、出力に識別子が残っていないことを確かめる
+// xml から読んだ患者の対応表は、まとめて処理したファイルをすべて出力できる場合にだけ登録する
+func processFiles(files []File, password string, outputFormats []string) ([]File, Summary, error) {
 	var anonymizedFiles []File
+	var identifiers []verify.Identifier
+	var ecgs []model.ECG
+	var summary Summary
+	sources := make(map[string]string) // 出力ファイル名と匿名化前のファイル名
 
 	for _, file := range files {
-		anonymizedFile, err := processFile(file, password)
+		// 匿名化後に残っていないかを確かめるため、匿名化前の識別子を読んでおく
+		ids, err := verify.Extract(file.Name, file.Content)
+		if err != nil {
+			log.Println("error in verify.Extract: ", err)
+		}
+		identifiers = append(identifiers, ids...)
+		identifiers = append(identifiers, storedIdentifiers(ids)...)
+
+		anonymizedFile, ecg, err := processFile(file, password)
 		if err != nil {
 			log.Println("error in processFile: ", err)
+			summary.Failed++
+			continue
+		}
+		if anonymizedFile.Content == nil {
+			summary.Skipped++
+			continue
+		}
+		summary.Anonymized++
+		anonymizedFiles = append(anonymizedFiles, anonymizedFile)
+		sources[anonymizedFile.Name] = file.Name
+		if ecg != nil {
+			ecgs = append(ecgs, *ecg)
+		}
+
+		// 匿名化したmwfを指定された形式にも変換する
+		convertedFiles, err := convertFile(anonymizedFile, outputFormats)
+		if err != nil {
+			log.Println("error in convertFile: ", err)
+			continue
+		}
+		for _, converted := range convertedFiles {
+			sources[converted.Name] = file.Name
+		}
+		anonymizedFiles = append(anonymizedFiles, convertedFiles...)
+	}
+
+	if err := verifyFiles(anonymizedFiles, identifiers, sources); err != nil {
+		summary.Failed += summary.Anonymized
+		summary.Anonymized = 0
+		return nil, summary, err
+	}
+	if err := putECGs(ecgs); err != nil {
+		summary.Failed += summary.Anonymized
+		summary.Anonymized = 0
+		return nil, summary, err
+	}
+	summary.Outputs = len(anonymizedFiles)
+	return anonymizedFiles, summary, nil
+}
+
+// storedIdentifiers はファイルに書かれた患者IDについて、登録済みの対応表の氏名と生年月日から識別子を作る
+// mwf の出力を、別にアップロードした xml の患者情報とも照合するために使う
+func storedIdentifiers(ids []verify.Identifier) []verify.Identifier {
+	db, err := model.GetDB(os.Getenv("DSN"))
+	if err != nil {
+		log.Println("error in GetDB: ", err)
+		return nil
+	}
+	defer db.Close()
+
+	var result []verify.Identifier
+	for _, id := range ids {
+		if id.Kind != verify.KindPatientID {
 			continue
 		}
-		if anonymizedFile.Content != nil {
-			anonymizedFiles = append(anonymizedFiles, anonymizedFile)
+		ecgs, err := model.GetECGsByPatientID(db, id.Value)
+		if err != nil {
+			log.Println("error in GetECGsByPatientID: ", err)
+			continue
 		}
+		for _, ecg := range ecgs {
+			result = append(result, verify.Identifiers(ecg.PatientID, []string{ecg.Name}, ecg.Birthtime)...)
+		}
+	}
+	return result
+}
+
+// putECGs は xml から読んだ患者の対応表を登録する
+func putECGs(ecgs []model.ECG) error {
+	if len(ecgs) == 0 {
+		return nil
+	}
+	db, err := model.GetDB(os.Getenv("DSN"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, ecg := range ecgs {
+		if err := model.Put(db, ecg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyFiles は出力ファイルに匿名化前の識別子が残っていないかを調べる
+// 1つでも残っていた場合はまとめて処理したファイルをすべて出力せず、残っていた位置を *verify.ResidualPIIError で返す
+func verifyFiles(files []File, identifiers []verify.Identifier, sources map[string]string) error {
+	var findings []verify.Finding
+	for _, file := range files {
+		for _, finding := range verify.Scan(file.Name, file.Content, identifiers) {
+			finding.Source = sources[file.Name]
+			findings = append(findings, finding)
+		}
+	}
+	for _, finding := range findings {
+		log.Println("residual PII:", finding)
 	}
-	return anonymizedFiles, nil
+	if len(findings) > 0 {
+		return &verify.ResidualPIIError{Findings: findings}
+	}
+	return nil
 }
 
-func processFile(file File, password string) (File, error) {
+// processFile は1つのファイルを匿名化する
+// xml の場合は登録する患者の対応表も返す (登録は呼び出し側で出力を確かめてから行う)
+func processFile(file File, password string) (File, *model.ECG, error) {
 	fileType := getFileType(file.Name)
 	if fileType == "" {
 		log.Println("non-mwf and non-xml file, and skipped it")
-		return File{}, nil // Skip non-MWF and non-XML files
+		return File{}, nil, nil // Skip non-MWF and non-XML files
 	}
 
 	exportID, date, err := parseFileName(file.Name)
 	if err != nil {
-		return File{}, err
+		return File{}, nil, err
 	}
 
 	db, err := model.GetDB(os.Getenv("DSN"))
 	if err != nil {
-		return File{}, err
+		return File{}, nil, err
 	}
 	defer db.Close()
 
-	var hashedID string
+	var hashedID, patientID string
+	var ecg *model.ECG
 	if fileType == ".xml" { // xmlの時には名前と生年月日を取得する
-		ecgID, patientID, name, birthtime, err := xml.GetPersonalInfo(file.Content)
+		info, err := xml.GetPersonalInfo(file.Content)
+		if err != nil {
+			// 患者IDが読めない場合は空のIDからハッシュIDを作らないようにファイルを受け付けない
+			return File{}, nil, fmt.Errorf("%s: %w", file.Name, err)
+		}
+		patientID = info.PatientID
+		hashedID, err = hashPatientID(patientID, password)
 		if err != nil {
-			log.Println("getPersonalInfo error: ", err)
+			return File{}, nil, err
 		}
-		hashedID = hashPatientID(patientID, password)
 
-		err = model.Put(db, model.ECG{
-			Id:        ecgID,
+		ecg = &model.ECG{
+			Id:        info.ECGID,
 			PatientID: patientID,
 			HashedId:  hashedID,
-			Name:      name,
-			Birthtime: birthtime,
+			Name:      info.Name,
+			Birthtime: info.BirthTime,
 			ExportID:  exportID,
-		})
-		if err != nil {
-			return File{}, err
 		}
 	} else if fileType == ".mwf" {
 		hashedID, err = model.GetHashedIDByExportID(db, exportID)
 		if err != nil {
 			log.Println("GetHashedIDByExportID error: ", err)
 		}
+		patientID, err = model.GetPatientIDByExportID(db, exportID)
+		if err != nil {
+			log.Println("GetPatientIDByExportID error: ", err)
+		}
+
+		// 対応する xml を処理していない場合は mwf の P_ID からハッシュIDとずらす日数を決める
+		if patientID == "" {
+			patientID, err = mwfPatientID(file.Content)
+			if err != nil {
+				return File{}, nil, fmt.Errorf("%s: %w", file.Name, err)
+			}
+			hashedID, err = hashPatientID(patientID, password)
+			if err != nil {
+				return File{}, nil, err
+			}
+		}
+	}
+
+	// 患者ごとに同じ日数だけ日付をずらす
+	// 日付をずらさずに出力すると記録日時が残るため、患者IDが分からないファイルは受け付けない
+	if patientID == "" {
+		return File{}, nil, fmt.Errorf("%s: %w", file.Name, errNoPatientID)
 	}
+	shiftDays := dateShiftDays(patientID, password)
 
-	anonymizedData, err := anonymizeData(file.Content, fileType)
+	anonymizedData, err := anonymizeData(file.Content, fileType, anonymizeOptions{
+		hashedID:  hashedID,
+		password:  password,
+		shiftDays: shiftDays,
+	})
 	if err != nil {
-		return File{}, fmt.Errorf("process file err: %w", err)
+		return File{}, nil, fmt.Errorf("process file err: %w", err)
 	}
 
-	anonymizedFileName := fmt.Sprintf("%s_%s%s", hashedID, date, fileType)
+	// ファイル名の日付も中身と同じ日数だけずらす
+	shiftedDate, err := shiftFileDate(date, shiftDays)
+	if err != nil {
+		return File{}, nil, fmt.Errorf("%s: %w", file.Name, err)
+	}
+	anonymizedFileName := fmt.Sprintf("%s_%s%s", hashedID, shiftedDate, fileType)
 	return File{
 		Name:    anonymizedFileName,
 		Content: anonymizedData,
-	}, nil
+	}, ecg, nil
+}
+
+// mwfPatientID は mwf の P_ID タグから患者IDを読む
+func mwfPatientID(content []byte) (string, error) {
+	f, err := mfer.Parse(content)
+	if err != nil {
+		return "", err
+	}
+	tag := f.Find(mfer.P_ID)
+	if tag == nil {
+		return "", errNoPatientID
+	}
+	patientID, err := f.DecodeString(tag.Contents)
+	if err != nil {
+		return "", err
+	}
+	patientID = strings.Trim(patientID, " \x00")
+	if patientID == "" {
+		return "", errNoPatientID
+	}
+	return patientID, nil
 }
 
 func getFileType(filename string) string {
@@ -332,39 +542,125 @@ func parseFileName(filename string) (string, string, error) {
 	return parts[0], parts[1], nil
 }
 
+// fileDateLayouts はファイル名の日付として受け付ける形式
+var fileDateLayouts = []string{"20060102", "200601021504", "20060102150405", "2006-01-02"}
+
+// shiftFileDate はファイル名の日付を days 日ずらし、元と同じ形式で返す
+// 日付として読めない場合は記録日が残らないようにエラーにする
+func shiftFileDate(date string, days int) (string, error) {
+	for _, layout := range fileDateLayouts {
+		if len(layout) != len(date) {
+			continue
+		}
+		t, err := time.Parse(layout, date)
+		if err != nil {
+			continue
+		}
+		return t.AddDate(0, 0, days).Format(layout), nil
+	}
+	return "", errFileNameFormat
+}
+
+// anonymizeOptions は1ファイルの匿名化に使う設定
+type anonymizeOptions struct {
+	hashedID  string
+	password  string
+	shiftDays int
+}
+
 func anonymizeData(
 	data []byte,
 	fileType string,
+	opts anonymizeOptions,
 ) ([]byte, error) {
 	switch fileType {
 	case ".mwf":
-		return mfer.Anonymize(data)
+		mferOpts, err := mferOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return mfer.AnonymizeWithOptions(data, mferOpts)
 	case ".xml":
-		return xml.Anonymize(data)
+		xmlOpts, err := xmlOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return xml.AnonymizeWithOptions(data, xmlOpts)
 	default:
 		return nil, fmt.Errorf("unsupported file type: %s", fileType)
 	}
 }
 
-func hashPatientID(patientID, password string) string {
-	// 新しいハッシュIDを生成
-	newHashedID := sha256.Sum256([]byte(patientID + password))
-	hashedIDStr := hex.EncodeToString(newHashedID[:])
+func mferOptions(opts anonymizeOptions) (mfer.Options, error) {
+	mferOpts := mfer.Options{
+		DateShiftDays: opts.shiftDays,
+		PseudonymKey:  derivedKey(opts.password, keyLabelUID),
+	}
 
-	return hashedIDStr
+	// MWF_PSEUDONYMIZE が有効な場合は患者IDを削除せずハッシュIDで置き換える
+	if os.Getenv("MWF_PSEUDONYMIZE") == "true" {
+		mferOpts.PseudonymID = opts.hashedID
+	}
+
+	// MWF_TEXT_ACTIONS でコメントなどの自由記述タグの扱い方を変更できる
+	if env := os.Getenv("MWF_TEXT_ACTIONS"); env != "" {
+		actions, err := mfer.ParseTextActions(env)
+		if err != nil {
+			return mfer.Options{}, fmt.Errorf("invalid MWF_TEXT_ACTIONS: %w", err)
+		}
+		mferOpts.TextActions = actions
+	}
+	return mferOpts, nil
+}
+
+func xmlOptions(opts anonymizeOptions) (xml.Options, error) {
+	xmlOpts := xml.Options{
+		PreserveFormat: true,
+		HashKey:        derivedKey(opts.password, keyLabelXMLHash),
+		DateShiftDays:  opts.shiftDays,
+	}
+
+	// XML_POLICY で匿名化の規則を書いた YAML または JSON のファイルを指定できる
+	if path := os.Getenv("XML_POLICY"); path != "" {
+		policy, err := xml.LoadPolicy(path)
+		if err != nil {
+			return xml.Options{}, fmt.Errorf("invalid XML_POLICY: %w", err)
+		}
+		xmlOpts.Policy = policy
+	}
+	return xmlOpts, nil
 }
 
-func sendZipResponse(c *gin.Context, zipBuffer *bytes.Buffer, conn *websocket.Conn) {
+// dateShiftDays はパスワードと患者IDから -365〜365 日(0を除く)のずらし日数を決める
+// 同じ患者の記録は同じ日数だけずれるため、記録間の間隔は保たれる
+func dateShiftDays(patientID, password string) int {
+	mac := hmac.New(sha256.New, derivedKey(password, keyLabelDateShift))
+	mac.Write([]byte(patientID))
+	sum := mac.Sum(nil)
+
+	days := int(binary.BigEndian.Uint32(sum[:4])%730) - 365
+	if days >= 0 {
+		days++
+	}
+	return days
+}
+
+// sendZipResponse はメタデータと ZIP ファイルを送る
+// findings があれば、出力しなかったファイルとして識別子の残っていた位置をメタデータに含める
+func sendZipResponse(c *gin.Context, zipBuffer *bytes.Buffer, conn *websocket.Conn, findings []verify.Finding) {
 
 	// 現在の時刻を使用してZIPファイル名を生成
 	loc, _ := time.LoadLocation("Asia/Tokyo")
 	anonymizedZipFileName := fmt.Sprintf("%s.zip", time.Now().In(loc).Format("2006-01-02_15-04-05"))
 
 	// メタデータを先に送信（例：ファイル名、サイズなど）
-	metaData := map[string]string{
+	metaData := map[string]any{
 		"fileName": anonymizedZipFileName,
 		"fileType": contentTypeZip,
 	}
+	if len(findings) > 0 {
+		metaData["residualPII"] = findings
+	}
 
 	if err := conn.WriteJSON(metaData); err != nil {
 		log.Println("error writeJSON: ", err)
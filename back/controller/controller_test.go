@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/shikidalab/anonymize-ecg/mfer"
+	"github.com/shikidalab/anonymize-ecg/model"
+	"github.com/shikidalab/anonymize-ecg/verify"
+)
+
+func TestProcessFileUnpairedMWF(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "test.db")
+	t.Setenv("DSN", dsn)
+	t.Setenv("PSEUDONYM_SCHEME", "")
+	if err := model.SetupDB(dsn); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	w := &mfer.Waveform{
+		SamplingRate: 500,
+		Channels:     []mfer.Channel{{Lead: "I", Resolution: 2.5, Raw: []float64{1, 2, 3}}},
+	}
+	newMWF := func(patientID string) []byte {
+		f, err := mfer.NewFile(mfer.Header{PatientID: patientID, Time: start}, w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f.Encode()
+	}
+
+	// 対応する xml がなくても P_ID から日付をずらす日数を決める
+	got, _, err := processFile(File{Name: "E6_20240101.mwf", Content: newMWF("12345")}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashedID, err := hashPatientID("12345", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ファイル名の日付も中身と同じ日数だけずらす
+	days := dateShiftDays("12345", "password")
+	if want := hashedID + "_" + start.AddDate(0, 0, days).Format("20060102") + ".mwf"; got.Name != want {
+		t.Errorf("unexpected name, got: %s, want: %s", got.Name, want)
+	}
+	if strings.Contains(got.Name, "20240101") {
+		t.Errorf("name contains the recording date: %s", got.Name)
+	}
+	f, err := mfer.Parse(got.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shifted, err := mfer.DecodeTime(f.Find(mfer.TIME).Contents, f.ByteOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := start.AddDate(0, 0, days); !shifted.Equal(want) {
+		t.Errorf("unexpected time, got: %v, want: %v", shifted, want)
+	}
+
+	// 患者IDが分からない場合は日付をずらせないので受け付けない
+	if _, _, err := processFile(File{Name: "E7_20240101.mwf", Content: newMWF("")}, "password"); !errors.Is(err, errNoPatientID) {
+		t.Errorf("expected errNoPatientID, got: %v", err)
+	}
+}
+
+func TestProcessFilesResidualPII(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "test.db")
+	t.Setenv("DSN", dsn)
+	t.Setenv("PSEUDONYM_SCHEME", "")
+	if err := model.SetupDB(dsn); err != nil {
+		t.Fatal(err)
+	}
+
+	// 既定の規則では残るコメントに患者名が書かれている xml
+	content := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<AnnotatedECG xmlns="urn:hl7-org:v3">
+  <id extension="ECG-0001"/>
+  <text>recorded for Yamada</text>
+  <subject>
+    <patient>
+      <patientPatient>
+        <id extension="PID-1001"/>
+        <name><given>Taro</given><family>Yamada</family></name>
+        <birthTime value="19841123"/>
+      </patientPatient>
+    </patient>
+  </subject>
+</AnnotatedECG>`)
+
+	files, summary, err := processFiles([]File{{Name: "E1_20240101.xml", Content: content}}, "password", nil)
+	var residual *verify.ResidualPIIError
+	if !errors.As(err, &residual) || !errors.Is(err, verify.ErrResidualPII) {
+		t.Fatalf("expected residual PII error, got: %v", err)
+	}
+	if len(files) != 0 || summary.Anonymized != 0 || summary.Failed != 1 {
+		t.Errorf("unexpected result: %v, %+v", files, summary)
+	}
+	for _, finding := range residual.Findings {
+		if finding.Source != "E1_20240101.xml" || finding.Kind != verify.KindName {
+			t.Errorf("unexpected finding: %v", finding)
+		}
+	}
+
+	// 出力しなかった xml の患者は対応表に登録しない
+	db, err := model.GetDB(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := model.GetHashedIDByExportID(db, "E1"); err == nil {
+		t.Error("patient of the dropped xml is registered")
+	}
+}
+
+func TestProcessFilesStoredPII(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "test.db")
+	t.Setenv("DSN", dsn)
+	t.Setenv("PSEUDONYM_SCHEME", "")
+	t.Setenv("MWF_TEXT_ACTIONS", "COMMENT=keep")
+	if err := model.SetupDB(dsn); err != nil {
+		t.Fatal(err)
+	}
+
+	// 前にアップロードした xml の患者の対応表
+	db, err := model.GetDB(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := model.Put(db, model.ECG{Id: "ECG-0001", PatientID: "PID-1001", HashedId: "h", ExportID: "E1", Name: "Yamada", Birthtime: "19841123"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 患者名のないmwfのコメントに、対応表にある患者名が書かれている
+	w := &mfer.Waveform{
+		SamplingRate: 500,
+		Channels:     []mfer.Channel{{Lead: "I", Resolution: 2.5, Raw: []float64{1, 2, 3}}},
+	}
+	f, err := mfer.NewFile(mfer.Header{PatientID: "PID-1001", Time: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)}, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comment, err := f.EncodeString("recorded for Yamada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Insert(mfer.NewTag(mfer.COMMENT, comment))
+
+	_, _, err = processFiles([]File{{Name: "E2_20240101.mwf", Content: f.Encode()}}, "password", nil)
+	var residual *verify.ResidualPIIError
+	if !errors.As(err, &residual) {
+		t.Fatalf("expected residual PII error, got: %v", err)
+	}
+	for _, finding := range residual.Findings {
+		if finding.Source != "E2_20240101.mwf" || finding.Kind != verify.KindName {
+			t.Errorf("unexpected finding: %v", finding)
+		}
+	}
+}
+
+func TestShiftFileDate(t *testing.T) {
+	tests := []struct {
+		date string
+		days int
+		want string
+	}{
+		{"20240101", -1, "20231231"},
+		{"202402281030", 1, "202402291030"},
+		{"20240101093000", 31, "20240201093000"},
+		{"2024-12-31", 1, "2025-01-01"},
+	}
+	for _, tt := range tests {
+		got, err := shiftFileDate(tt.date, tt.days)
+		if err != nil {
+			t.Errorf("%s: %v", tt.date, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got: %s, want: %s", tt.date, got, tt.want)
+		}
+	}
+
+	// 日付として読めない場合はそのまま残さない
+	for _, date := range []string{"", "0101", "2024011", "20241301"} {
+		if _, err := shiftFileDate(date, 1); !errors.Is(err, errFileNameFormat) {
+			t.Errorf("%q: expected errFileNameFormat, got: %v", date, err)
+		}
+	}
+}
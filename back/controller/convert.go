@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shikidalab/anonymize-ecg/edf"
+	"github.com/shikidalab/anonymize-ecg/mfer"
+	"github.com/shikidalab/anonymize-ecg/wfdb"
+)
+
+const (
+	outputFormatWFDB = "wfdb"
+	outputFormatEDF  = "edf"
+)
+
+func isOutputFormat(format string) bool {
+	switch format {
+	case outputFormatWFDB, outputFormatEDF:
+		return true
+	default:
+		return false
+	}
+}
+
+// convertFile は匿名化したmwfファイルを outputFormats の形式に変換する
+// mwf以外のファイルは変換しない
+func convertFile(file File, outputFormats []string) ([]File, error) {
+	if len(outputFormats) == 0 || getFileType(file.Name) != ".mwf" {
+		return nil, nil
+	}
+
+	f, err := mfer.Parse(file.Content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file.Name, err)
+	}
+	waveform, err := f.Waveform()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode waveform of %s: %w", file.Name, err)
+	}
+
+	hashedID, _, err := parseFileName(file.Name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file.Name, err)
+	}
+	start, hasStart := startTime(f)
+	name := convertedName(hashedID, start, hasStart)
+
+	var files []File
+	for _, format := range outputFormats {
+		switch format {
+		case outputFormatWFDB:
+			records, err := wfdb.Write(name, waveform)
+			if err != nil {
+				return nil, fmt.Errorf("failed to write WFDB record of %s: %w", file.Name, err)
+			}
+			for _, record := range records {
+				files = append(files, File{Name: record.Name, Content: record.Content})
+			}
+		case outputFormatEDF:
+			content, err := edf.Write(edf.Header{PatientID: hashedID, Start: start}, waveform)
+			if err != nil {
+				return nil, fmt.Errorf("failed to write EDF+ file of %s: %w", file.Name, err)
+			}
+			files = append(files, File{Name: name + ".edf", Content: content})
+		}
+	}
+	return files, nil
+}
+
+// startTime は匿名化済みのmwfの日付をずらした TIME タグの値を読む
+func startTime(f *mfer.File) (time.Time, bool) {
+	tag := f.Find(mfer.TIME)
+	if tag == nil {
+		return time.Time{}, false
+	}
+	start, err := mfer.DecodeTime(tag.Contents, f.ByteOrder())
+	if err != nil {
+		return time.Time{}, false
+	}
+	return start, true
+}
+
+// convertedName は変換したファイルの名前 (WFDB のレコード名) を作る
+// 入力のファイル名の日付は使わず、ハッシュIDと日付をずらした記録開始日だけで表す
+func convertedName(hashedID string, start time.Time, hasStart bool) string {
+	if !hasStart {
+		return hashedID
+	}
+	return hashedID + "_" + start.Format("20060102")
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shikidalab/anonymize-ecg/mfer"
+)
+
+func TestConvertFileWithPseudonym(t *testing.T) {
+	t.Setenv("PSEUDONYM_SCHEME", SchemeHMAC)
+	hashedID, err := hashPatientID("12345", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &mfer.Waveform{
+		SamplingRate: 500,
+		Channels:     []mfer.Channel{{Lead: "I", Resolution: 2.5, Raw: []float64{1, 2, 3}}},
+	}
+	f, err := mfer.NewFile(mfer.Header{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 変換したファイルの名前はファイル名の日付ではなく、日付をずらした TIME タグの日付で作る
+	name := hashedID + "_20240101"
+	files, err := convertFile(File{Name: hashedID + "_20240315.mwf", Content: f.Encode()}, []string{outputFormatWFDB, outputFormatEDF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.Contains(file.Name, "20240315") || strings.Contains(string(file.Content), "20240315") {
+			t.Errorf("%s contains the date of the input file name", file.Name)
+		}
+	}
+	if len(files) != 3 || files[0].Name != name+".hea" || files[1].Name != name+".dat" || files[2].Name != name+".edf" {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+	// ヘッダの先頭はレコード名
+	if !strings.HasPrefix(string(files[0].Content), name+" ") {
+		t.Errorf("unexpected WFDB header: %q", files[0].Content)
+	}
+}
+
+func TestConvertFileWithoutTime(t *testing.T) {
+	w := &mfer.Waveform{
+		SamplingRate: 500,
+		Channels:     []mfer.Channel{{Lead: "I", Resolution: 2.5, Raw: []float64{1, 2, 3}}},
+	}
+	f, err := mfer.NewFile(mfer.Header{}, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 記録開始日時が分からない場合はハッシュIDだけで表す
+	files, err := convertFile(File{Name: "abcd_20240315.mwf", Content: f.Encode()}, []string{outputFormatWFDB, outputFormatEDF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || files[0].Name != "abcd.hea" || files[1].Name != "abcd.dat" || files[2].Name != "abcd.edf" {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+}
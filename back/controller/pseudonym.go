@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// 患者IDから仮名IDを作る方式 (PSEUDONYM_SCHEME で選ぶ)
+const (
+	// SchemeHMAC はパスワードから PBKDF2 で導いた鍵による HMAC-SHA256 (既定)
+	SchemeHMAC = "hmac-v1"
+	// SchemeLegacy は以前の sha256(患者ID + パスワード)
+	// 以前に匿名化したデータと仮名IDを合わせる場合にだけ使う
+	SchemeLegacy = "legacy"
+)
+
+const (
+	// pseudonymPrefix は SchemeHMAC の仮名IDの接頭辞
+	// 方式を変えた場合に以前の仮名IDと区別できるよう版を付ける
+	// 仮名IDはファイル名と WFDB のレコード名に使うため、英数字のみにする
+	// ("_" はファイル名の区切りなので使わない。16進数にない "v" で以前の仮名IDと区別できる)
+	pseudonymPrefix = "v1"
+
+	// 仮名IDは実行のたびに同じでなければならないため、ソルトは固定の値にする
+	pseudonymSalt       = "anonymize-ecg/pseudonym/v1"
+	pseudonymIterations = 600000
+)
+
+// derivedKey の用途ごとのラベル
+// 1つの鍵を使い回さないよう、仮名ID以外の HMAC には用途ごとに導いた鍵を使う
+const (
+	keyLabelUID       = "anonymize-ecg/mwf-uid/v1"
+	keyLabelXMLHash   = "anonymize-ecg/xml-hash/v1"
+	keyLabelDateShift = "anonymize-ecg/date-shift/v1"
+)
+
+// pseudonymKeys はパスワードから導いた鍵
+// PBKDF2 は時間がかかるため、パスワードごとに一度だけ計算する
+// パスワードをメモリに残さないよう、パスワードの SHA-256 を鍵にして持つ
+var pseudonymKeys = struct {
+	sync.Mutex
+	keys map[[sha256.Size]byte][]byte
+}{keys: make(map[[sha256.Size]byte][]byte)}
+
+// hashPatientID は患者IDとパスワードから仮名IDを作る
+func hashPatientID(patientID, password string) (string, error) {
+	switch scheme := os.Getenv("PSEUDONYM_SCHEME"); scheme {
+	case "", SchemeHMAC:
+		mac := hmac.New(sha256.New, pseudonymKey(password))
+		mac.Write([]byte(patientID))
+		return pseudonymPrefix + hex.EncodeToString(mac.Sum(nil)), nil
+	case SchemeLegacy:
+		// 連結するため "12"+"3ab" と "123"+"ab" が同じ値になり、パスワードが短いと総当たりで患者IDを求められる
+		sum := sha256.Sum256([]byte(patientID + password))
+		return hex.EncodeToString(sum[:]), nil
+	default:
+		return "", fmt.Errorf("unsupported PSEUDONYM_SCHEME %q", scheme)
+	}
+}
+
+func pseudonymKey(password string) []byte {
+	id := sha256.Sum256([]byte(password))
+	pseudonymKeys.Lock()
+	key, ok := pseudonymKeys.keys[id]
+	pseudonymKeys.Unlock()
+	if ok {
+		return key
+	}
+
+	// 導出の間は他のセッションを止めないようロックの外で計算する
+	// 同じパスワードで同時に計算した場合も結果は同じなので、後から書いた値で上書きしてよい
+	key = pbkdf2.Key([]byte(password), []byte(pseudonymSalt), pseudonymIterations, sha256.Size, sha256.New)
+	pseudonymKeys.Lock()
+	pseudonymKeys.keys[id] = key
+	pseudonymKeys.Unlock()
+	return key
+}
+
+// derivedKey はパスワードから導いた鍵から、label の用途に使う鍵を作る
+func derivedKey(password, label string) []byte {
+	mac := hmac.New(sha256.New, pseudonymKey(password))
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
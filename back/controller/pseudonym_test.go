@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashPatientID(t *testing.T) {
+	t.Setenv("PSEUDONYM_SCHEME", "")
+	first, err := hashPatientID("12", "3ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(first, pseudonymPrefix) || len(first) != len(pseudonymPrefix)+64 {
+		t.Errorf("unexpected pseudonym: %s", first)
+	}
+	if again, _ := hashPatientID("12", "3ab"); again != first {
+		t.Errorf("pseudonym is not deterministic: %s, %s", first, again)
+	}
+	// 患者IDとパスワードの区切りがずれても同じ値にならない
+	if other, _ := hashPatientID("123", "ab"); other == first {
+		t.Errorf("ambiguous pseudonym: %s", other)
+	}
+
+	// 以前の方式では同じ値になる
+	t.Setenv("PSEUDONYM_SCHEME", SchemeLegacy)
+	legacy, err := hashPatientID("12", "3ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "982357496c6e531cba092c30369173cf0277e81553aa215c0c4c06529d05ea30"; legacy != want {
+		t.Errorf("unexpected legacy pseudonym, got: %s, want: %s", legacy, want)
+	}
+	if other, _ := hashPatientID("123", "ab"); other != legacy {
+		t.Errorf("legacy pseudonym changed, got: %s, want: %s", other, legacy)
+	}
+
+	t.Setenv("PSEUDONYM_SCHEME", "md5")
+	if _, err := hashPatientID("12", "3ab"); err == nil {
+		t.Error("expected error for unknown scheme")
+	}
+}
+
+func TestDerivedKey(t *testing.T) {
+	// 用途ごとに異なる鍵を使い、パスワードそのものは HMAC の鍵にしない
+	keys := make(map[string]string)
+	for _, label := range []string{keyLabelUID, keyLabelXMLHash, keyLabelDateShift} {
+		key := derivedKey("password", label)
+		if string(key) == "password" || string(key) == string(pseudonymKey("password")) {
+			t.Errorf("%s: key is not derived", label)
+		}
+		if other, ok := keys[string(key)]; ok {
+			t.Errorf("%s: same key as %s", label, other)
+		}
+		keys[string(key)] = label
+	}
+
+	opts := anonymizeOptions{password: "password"}
+	mferOpts, err := mferOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xmlOpts, err := xmlOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys[string(mferOpts.PseudonymKey)] != keyLabelUID || keys[string(xmlOpts.HashKey)] != keyLabelXMLHash {
+		t.Error("anonymizers are not given the derived keys")
+	}
+}
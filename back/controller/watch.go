@@ -0,0 +1,321 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/shikidalab/anonymize-ecg/model"
+)
+
+// 監視フォルダから取り込んだファイルの処理結果
+const (
+	statusAnonymized = "anonymized" // 匿名化して出力した
+	statusFailed     = "failed"     // 匿名化できなかった
+	statusDuplicate  = "duplicate"  // 同じ内容のファイルを処理済みだった
+	statusUnpaired   = "unpaired"   // 対応する xml を匿名化できなかった mwf
+)
+
+// WatchOptions は監視フォルダの設定
+type WatchOptions struct {
+	Dir           string        // 監視するディレクトリ (サブディレクトリは読まない)
+	Output        string        // 匿名化したファイルを書くディレクトリ
+	Archive       string        // 匿名化できた元のファイルの移動先
+	Quarantine    string        // 匿名化できなかった元のファイルの移動先
+	Password      string        // 匿名化に使うパスワード
+	OutputFormats []string      // mwf から追加で変換する形式
+	Interval      time.Duration // ディレクトリを読む間隔
+	PairWait      time.Duration // xml と mwf の片方しかない場合にもう片方を待つ時間
+}
+
+// fileState はファイルの大きさと更新日時の前回の値
+type fileState struct {
+	size        int64
+	modTime     time.Time
+	stableSince time.Time // 前回と変わっていないことを最初に確かめた時刻 (ゼロ値の場合は書き込み中の可能性がある)
+}
+
+// pair は同じエクスポートIDの xml と mwf
+// どちらかが空の場合もある
+type pair struct {
+	exportID string
+	xml      string
+	mwf      string
+}
+
+// watcher は監視フォルダのファイルの状態を持つ
+type watcher struct {
+	opts  WatchOptions
+	seen  map[string]fileState
+	known func(exportID string) bool // xml を処理済みのエクスポートIDかどうか
+}
+
+// Watch は opts.Dir を定期的に読み、書き込みが終わった mwf と xml を Webからのアップロードと同じ手順で匿名化する
+// ctx が終了するまで戻らない
+func Watch(ctx context.Context, opts WatchOptions) error {
+	if opts.Dir == "" || opts.Output == "" || opts.Archive == "" || opts.Quarantine == "" {
+		return errors.New("watch, output, archive and quarantine directories are required")
+	}
+	for _, format := range opts.OutputFormats {
+		if !isOutputFormat(format) {
+			return fmt.Errorf("error output format %q: %w", format, errOutputFormat)
+		}
+	}
+	for _, dir := range []string{opts.Output, opts.Archive, opts.Quarantine} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	w := &watcher{
+		opts: opts,
+		seen: make(map[string]fileState),
+		known: func(exportID string) bool {
+			db, err := model.GetDB(os.Getenv("DSN"))
+			if err != nil {
+				return false
+			}
+			defer db.Close()
+			_, err = model.GetHashedIDByExportID(db, exportID)
+			return err == nil
+		},
+	}
+
+	log.Printf("watching %s", opts.Dir)
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		pairs, err := w.poll(time.Now())
+		if err != nil {
+			log.Println("error in watch: ", err)
+		}
+		for _, p := range pairs {
+			w.process(p)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll はディレクトリを読み、処理できるようになった組を返す
+// 大きさと更新日時が前回読んだときから変わっていないファイルを書き込みが終わったものとみなす
+func (w *watcher) poll(now time.Time) ([]pair, error) {
+	entries, err := os.ReadDir(w.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	present := make(map[string]bool)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || getFileType(entry.Name()) == "" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		name := entry.Name()
+		present[name] = true
+		prev, ok := w.seen[name]
+		if ok && prev.size == info.Size() && prev.modTime.Equal(info.ModTime()) {
+			if prev.stableSince.IsZero() {
+				prev.stableSince = now
+				w.seen[name] = prev
+			}
+			continue
+		}
+		w.seen[name] = fileState{size: info.Size(), modTime: info.ModTime()}
+	}
+	for name := range w.seen {
+		if !present[name] {
+			delete(w.seen, name)
+		}
+	}
+
+	// エクスポートIDごとにまとめる
+	// ファイル名の形式が違うものは1つずつ処理し、processFile のエラーとして隔離する
+	groups := make(map[string]*pair)
+	unstable := make(map[string]bool)
+	oldest := make(map[string]time.Time)
+	for name, state := range w.seen {
+		exportID, _, err := parseFileName(name)
+		if err != nil {
+			exportID = name
+		}
+		p, ok := groups[exportID]
+		if !ok {
+			p = &pair{exportID: exportID}
+			groups[exportID] = p
+		}
+		if getFileType(name) == ".xml" {
+			p.xml = name
+		} else {
+			p.mwf = name
+		}
+		if state.stableSince.IsZero() {
+			unstable[exportID] = true
+		} else if t, ok := oldest[exportID]; !ok || state.stableSince.Before(t) {
+			oldest[exportID] = state.stableSince
+		}
+	}
+
+	var ready []pair
+	for exportID, p := range groups {
+		if unstable[exportID] {
+			continue
+		}
+		waited := now.Sub(oldest[exportID]) >= w.opts.PairWait
+		switch {
+		case p.xml != "" && p.mwf != "":
+		case p.xml == "" && w.known(exportID):
+			// xml は以前に処理済み
+		case waited:
+		default:
+			continue
+		}
+		ready = append(ready, *p)
+		delete(w.seen, p.xml)
+		delete(w.seen, p.mwf)
+	}
+	sort.Slice(ready, func(i, j int) bool { return ready[i].exportID < ready[j].exportID })
+	return ready, nil
+}
+
+// process は組の xml、mwf の順に匿名化する
+// xml を匿名化できなかった場合は、以前に同じエクスポートIDの xml を処理していなければ mwf も隔離する
+// xml がない mwf は Webからのアップロードと同じく mwf の患者ID (P_ID) から匿名化し、患者IDがなければ隔離する
+func (w *watcher) process(p pair) {
+	if p.xml == "" {
+		if p.mwf != "" {
+			w.processOne(p.mwf, "")
+		}
+		return
+	}
+
+	xmlStatus := w.processOne(p.xml, "")
+	if p.mwf != "" {
+		status := ""
+		if xmlStatus != statusAnonymized && !w.known(p.exportID) {
+			status = statusUnpaired
+		}
+		w.processOne(p.mwf, status)
+	}
+}
+
+// processOne は1つのファイルを匿名化し、元のファイルを移動して結果を記録する
+// status が空でない場合は匿名化せずにその結果として隔離する
+// 記録した結果を返す (ファイルを読めなかった場合などは空文字列)
+func (w *watcher) processOne(name, status string) string {
+	path := filepath.Join(w.opts.Dir, name)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		log.Println("error reading watched file: ", err)
+		return ""
+	}
+	sum := sha256.Sum256(content)
+	record := model.ProcessedFile{SHA256: hex.EncodeToString(sum[:]), Name: name, Status: status}
+
+	db, err := model.GetDB(os.Getenv("DSN"))
+	if err != nil {
+		log.Println("error in GetDB: ", err)
+		return ""
+	}
+	defer db.Close()
+
+	if status == "" {
+		previous, err := model.GetProcessedFiles(db, record.SHA256)
+		if err != nil {
+			log.Println("error in GetProcessedFiles: ", err)
+			return ""
+		}
+		for _, p := range previous {
+			if p.Status == statusAnonymized {
+				record.Status = statusDuplicate
+				record.Message = fmt.Sprintf("same content as %s", p.Name)
+				break
+			}
+		}
+	}
+
+	if record.Status == "" {
+		record.Status = statusAnonymized
+		if err := w.anonymize(File{Name: name, Content: content}); err != nil {
+			log.Printf("error anonymizing %s: %v", name, err)
+			record.Status = statusFailed
+			record.Message = err.Error()
+		}
+	}
+
+	dest := w.opts.Archive
+	if record.Status == statusFailed || record.Status == statusUnpaired {
+		dest = w.opts.Quarantine
+	}
+	record.MovedTo, err = moveFile(path, dest)
+	if err != nil {
+		// 移動できないと次の読み込みで再び処理するため、記録だけは残す
+		log.Printf("error moving %s: %v", name, err)
+		record.Message = err.Error()
+	}
+	record.ProcessedAt = time.Now()
+	if err := model.PutProcessedFile(db, record); err != nil {
+		log.Println("error in PutProcessedFile: ", err)
+	}
+	log.Printf("watched file %s: %s", name, record.Status)
+	return record.Status
+}
+
+// anonymize は1つのファイルを匿名化して出力先に書く
+func (w *watcher) anonymize(file File) error {
+	files, summary, err := processFiles([]File{file}, w.opts.Password, w.opts.OutputFormats)
+	if err != nil {
+		return err
+	}
+	if summary.Anonymized != 1 {
+		return errors.New("file could not be anonymized (see the log for details)")
+	}
+	write, closeOutput, err := openOutput(w.opts.Output)
+	if err != nil {
+		return err
+	}
+	if err := write(files); err != nil {
+		closeOutput()
+		return err
+	}
+	return closeOutput()
+}
+
+// moveFile はファイルを dir に移動し、移動先のパスを返す
+// 同じ名前のファイルがある場合は名前の前に日時を付ける
+func moveFile(path, dir string) (string, error) {
+	dest := filepath.Join(dir, filepath.Base(path))
+	if _, err := os.Stat(dest); err == nil {
+		dest = filepath.Join(dir, time.Now().Format("20060102150405.000000")+"_"+filepath.Base(path))
+	}
+	if err := os.Rename(path, dest); err == nil {
+		return dest, nil
+	}
+
+	// 共有フォルダなど別のファイルシステムへは移動できないため、コピーしてから削除する
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(dest, content, 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Remove(path); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/shikidalab/anonymize-ecg/mfer"
+	"github.com/shikidalab/anonymize-ecg/model"
+)
+
+func TestWatcherPoll(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := &watcher{
+		opts:  WatchOptions{Dir: dir, PairWait: time.Minute},
+		seen:  make(map[string]fileState),
+		known: func(exportID string) bool { return exportID == "E3" },
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	write("E1_20240101.xml", "<xml/>")
+	write("E1_20240101.mwf", "mwf")
+	write("E2_20240101.xml", "<xml/>")
+	write("E3_20240101.mwf", "mwf")
+	write("readme.txt", "ignored")
+
+	// 1回目は書き込み中かどうか分からないので何も返さない
+	pairs, err := w.poll(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 0 {
+		t.Fatalf("unexpected pairs on first poll: %+v", pairs)
+	}
+
+	// E1 はそろっていて、E3 は xml を処理済み、E2 は mwf を待つ
+	pairs, err = w.poll(now.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []pair{
+		{exportID: "E1", xml: "E1_20240101.xml", mwf: "E1_20240101.mwf"},
+		{exportID: "E3", mwf: "E3_20240101.mwf"},
+	}
+	if len(pairs) != len(want) {
+		t.Fatalf("unexpected pairs, got: %+v, want: %+v", pairs, want)
+	}
+	for i := range want {
+		if pairs[i] != want[i] {
+			t.Errorf("unexpected pair, got: %+v, want: %+v", pairs[i], want[i])
+		}
+	}
+
+	// 処理したファイルは移動される
+	for _, name := range []string{"E1_20240101.xml", "E1_20240101.mwf", "E3_20240101.mwf"} {
+		os.Remove(filepath.Join(dir, name))
+	}
+
+	// 書き込み中のファイルは大きさが変わらなくなるまで待つ
+	write("E2_20240101.mwf", "partial")
+	if pairs, _ := w.poll(now.Add(2 * time.Second)); len(pairs) != 0 {
+		t.Fatalf("unexpected pairs while writing: %+v", pairs)
+	}
+	pairs, _ = w.poll(now.Add(3 * time.Second))
+	if len(pairs) != 1 || pairs[0].xml != "E2_20240101.xml" || pairs[0].mwf != "E2_20240101.mwf" {
+		t.Fatalf("unexpected pairs after writing: %+v", pairs)
+	}
+}
+
+func TestWatcherPollPairWait(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "E4_20240101.xml"), []byte("<xml/>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := &watcher{
+		opts:  WatchOptions{Dir: dir, PairWait: time.Minute},
+		seen:  make(map[string]fileState),
+		known: func(string) bool { return false },
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	w.poll(now)
+	if pairs, _ := w.poll(now.Add(time.Second)); len(pairs) != 0 {
+		t.Fatalf("unexpected pairs before PairWait: %+v", pairs)
+	}
+	pairs, _ := w.poll(now.Add(2 * time.Minute))
+	if len(pairs) != 1 || pairs[0].xml != "E4_20240101.xml" || pairs[0].mwf != "" {
+		t.Fatalf("unexpected pairs after PairWait: %+v", pairs)
+	}
+}
+
+// newTestWatcher は一時ディレクトリを監視する watcher を作る
+func newTestWatcher(t *testing.T) *watcher {
+	dir := t.TempDir()
+	t.Setenv("DSN", filepath.Join(dir, "test.db"))
+	t.Setenv("PSEUDONYM_SCHEME", "")
+	if err := model.SetupDB(os.Getenv("DSN")); err != nil {
+		t.Fatal(err)
+	}
+	w := &watcher{
+		opts: WatchOptions{
+			Dir:        filepath.Join(dir, "in"),
+			Output:     filepath.Join(dir, "out"),
+			Archive:    filepath.Join(dir, "archive"),
+			Quarantine: filepath.Join(dir, "quarantine"),
+			Password:   "password",
+		},
+		seen:  make(map[string]fileState),
+		known: func(string) bool { return false },
+	}
+	for _, d := range []string{w.opts.Dir, w.opts.Output, w.opts.Archive, w.opts.Quarantine} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w
+}
+
+// processedStatus は内容が content のファイルについて記録された結果を返す
+func processedStatus(t *testing.T, content []byte) []string {
+	db, err := model.GetDB(os.Getenv("DSN"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sum := sha256.Sum256(content)
+	records, err := model.GetProcessedFiles(db, hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string
+	for _, record := range records {
+		statuses = append(statuses, record.Status)
+	}
+	return statuses
+}
+
+func TestWatcherProcessFailedXML(t *testing.T) {
+	w := newTestWatcher(t)
+
+	// 患者IDのない xml は匿名化できない
+	files := map[string]string{
+		"E5_20240101.xml": "<AnnotatedECG/>",
+		"E5_20240101.mwf": "not a mwf file",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(w.opts.Dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w.process(pair{exportID: "E5", xml: "E5_20240101.xml", mwf: "E5_20240101.mwf"})
+
+	for name := range files {
+		if _, err := os.Stat(filepath.Join(w.opts.Quarantine, name)); err != nil {
+			t.Errorf("%s is not quarantined: %v", name, err)
+		}
+	}
+	if entries, _ := os.ReadDir(w.opts.Output); len(entries) != 0 {
+		t.Errorf("unexpected output files: %v", entries)
+	}
+
+	if statuses := processedStatus(t, []byte(files["E5_20240101.mwf"])); len(statuses) != 1 || statuses[0] != statusUnpaired {
+		t.Errorf("unexpected records of mwf: %v", statuses)
+	}
+}
+
+func TestWatcherProcessUnpairedMWF(t *testing.T) {
+	w := newTestWatcher(t)
+
+	wf := &mfer.Waveform{
+		SamplingRate: 500,
+		Channels:     []mfer.Channel{{Lead: "I", Resolution: 2.5, Raw: []float64{1, 2, 3}}},
+	}
+	newMWF := func(patientID string) []byte {
+		f, err := mfer.NewFile(mfer.Header{PatientID: patientID, Time: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)}, wf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f.Encode()
+	}
+	files := map[string][]byte{
+		"E6_20240101.mwf": newMWF("12345"),
+		"E7_20240101.mwf": newMWF(""),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(w.opts.Dir, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// xml がなくても患者IDのある mwf は匿名化する
+	w.process(pair{exportID: "E6", mwf: "E6_20240101.mwf"})
+	if _, err := os.Stat(filepath.Join(w.opts.Archive, "E6_20240101.mwf")); err != nil {
+		t.Errorf("mwf with patient ID is not archived: %v", err)
+	}
+	if entries, _ := os.ReadDir(w.opts.Output); len(entries) != 1 {
+		t.Errorf("unexpected output files: %v", entries)
+	}
+	if statuses := processedStatus(t, files["E6_20240101.mwf"]); len(statuses) != 1 || statuses[0] != statusAnonymized {
+		t.Errorf("unexpected records of mwf with patient ID: %v", statuses)
+	}
+
+	// 患者IDがない mwf は日付をずらせないので隔離する
+	w.process(pair{exportID: "E7", mwf: "E7_20240101.mwf"})
+	if _, err := os.Stat(filepath.Join(w.opts.Quarantine, "E7_20240101.mwf")); err != nil {
+		t.Errorf("mwf without patient ID is not quarantined: %v", err)
+	}
+	if statuses := processedStatus(t, files["E7_20240101.mwf"]); len(statuses) != 1 || statuses[0] != statusFailed {
+		t.Errorf("unexpected records of mwf without patient ID: %v", statuses)
+	}
+}
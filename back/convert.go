@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shikidalab/anonymize-ecg/convert"
+)
+
+// runConvert は convert サブコマンドを実行する
+// 匿名化済みの xml を mwf に、mwf を xml に変換する
+func runConvert(args []string) error {
+	s := newSettings("convert", "convert [-out DIR] FILE...")
+	out := s.flags.String("out", "", "output directory (default: the directory of each file)")
+	if err := s.parse(args); err != nil {
+		return err
+	}
+	if s.flags.NArg() == 0 {
+		s.flags.Usage()
+		return errors.New("no files to convert")
+	}
+	if *out != "" {
+		if err := os.MkdirAll(*out, 0o755); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range s.flags.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var converted []byte
+		var ext string
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".xml":
+			converted, err = convert.XMLToMWF(data)
+			ext = ".mwf"
+		case ".mwf":
+			converted, err = convert.MWFToXML(data)
+			ext = ".xml"
+		default:
+			err = fmt.Errorf("unsupported file type: %s", path)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		dir := filepath.Dir(path)
+		if *out != "" {
+			dir = *out
+		}
+		dest := filepath.Join(dir, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))+ext)
+		// 同じ名前の mwf と xml は組で出力されるため、元のファイルを上書きしない
+		if _, err := os.Stat(dest); err == nil {
+			return fmt.Errorf("%s: %s already exists", path, dest)
+		}
+		if err := os.WriteFile(dest, converted, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("%s -> %s\n", path, dest)
+	}
+	return nil
+}
+
+// runCompare は compare サブコマンドを実行する
+// xml のリズム波形と mwf の波形が一致するかを調べる
+func runCompare(args []string) error {
+	s := newSettings("compare", "compare [-tolerance uV] FILE.xml FILE.mwf")
+	tolerance := s.flags.Float64("tolerance", 0, "allowed difference of each sample in uV")
+	if err := s.parse(args); err != nil {
+		return err
+	}
+	if s.flags.NArg() != 2 {
+		s.flags.Usage()
+		return errors.New("an xml file and an mwf file are required")
+	}
+
+	xmlPath, mwfPath := s.flags.Arg(0), s.flags.Arg(1)
+	if strings.EqualFold(filepath.Ext(xmlPath), ".mwf") {
+		xmlPath, mwfPath = mwfPath, xmlPath
+	}
+	xmlData, err := os.ReadFile(xmlPath)
+	if err != nil {
+		return err
+	}
+	mwfData, err := os.ReadFile(mwfPath)
+	if err != nil {
+		return err
+	}
+	if err := convert.Compare(xmlData, mwfData, *tolerance); err != nil {
+		return fmt.Errorf("%s and %s: %w", xmlPath, mwfPath, err)
+	}
+	fmt.Printf("%s and %s: waveforms match\n", xmlPath, mwfPath)
+	return nil
+}
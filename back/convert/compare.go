@@ -0,0 +1,65 @@
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/shikidalab/anonymize-ecg/mfer"
+	"github.com/shikidalab/anonymize-ecg/xml"
+)
+
+// ErrMismatch は XML と MWF の波形が一致しないことを表す
+var ErrMismatch = errors.New("waveforms do not match")
+
+// Compare は HL7 aECG のリズム波形と MWF の波形を比べる
+// 誘導の並び、サンプリング周波数、サンプル数、各サンプルの物理量(µV)が tolerance 以内で一致するかを確かめる
+func Compare(xmlData, mwfData []byte, tolerance float64) error {
+	aecg, err := xml.ParseAnnotatedECG(xmlData)
+	if err != nil {
+		return err
+	}
+	rhythm := aecg.Rhythm()
+	if rhythm == nil {
+		return xml.ErrNoWaveform
+	}
+	if err := rhythm.Validate(); err != nil {
+		return err
+	}
+
+	f, err := mfer.Parse(mwfData)
+	if err != nil {
+		return err
+	}
+	w, err := f.Waveform()
+	if err != nil {
+		return err
+	}
+	return CompareSeries(rhythm, w, tolerance)
+}
+
+// CompareSeries は aECG の series と MFER の波形を比べる
+func CompareSeries(s *xml.Series, w *mfer.Waveform, tolerance float64) error {
+	if math.Abs(s.SamplingRate-w.SamplingRate) > 1e-6*w.SamplingRate {
+		return fmt.Errorf("%w: sampling rate %v Hz and %v Hz", ErrMismatch, s.SamplingRate, w.SamplingRate)
+	}
+	if len(s.Leads) != len(w.Channels) {
+		return fmt.Errorf("%w: %d leads and %d channels", ErrMismatch, len(s.Leads), len(w.Channels))
+	}
+	for i, lead := range s.Leads {
+		ch := w.Channels[i]
+		if name := mdcToLead(lead.Code); name != ch.Lead {
+			return fmt.Errorf("%w: lead %d is %s and %s", ErrMismatch, i, name, ch.Lead)
+		}
+		samples := lead.Samples()
+		if len(samples) != len(ch.Samples) {
+			return fmt.Errorf("%w: lead %s has %d and %d samples", ErrMismatch, ch.Lead, len(samples), len(ch.Samples))
+		}
+		for n, v := range samples {
+			if math.Abs(v-ch.Samples[n]) > tolerance {
+				return fmt.Errorf("%w: sample %d of %s is %v uV and %v uV", ErrMismatch, n, ch.Lead, v, ch.Samples[n])
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shikidalab/anonymize-ecg/mfer"
+	"github.com/shikidalab/anonymize-ecg/xml"
+)
+
+// mdcLeadPrefix は HL7 aECG の誘導コード(MDC_ECG_LEAD_I など)の接頭辞
+const mdcLeadPrefix = "MDC_ECG_LEAD_"
+
+// tsLayout は HL7 TS 形式の日時のレイアウト
+const tsLayout = "20060102150405"
+
+// slashBirthTime は以前の xml.Anonymize が書いていた YYYY/M 形式の生年月
+var slashBirthTime = regexp.MustCompile(`^(\d{4})/(\d{1,2})$`)
+
+// pseudonymID は匿名化で書かれる患者IDの形式
+// 仮名 (v1 に続く HMAC-SHA256、または以前の SHA-256) と、XML の規則の hash (HMAC-SHA256 の前半) に一致する
+var pseudonymID = regexp.MustCompile(`^(v1)?[0-9a-f]{32}([0-9a-f]{32})?$`)
+
+// ErrNotAnonymized は変換する文書に匿名化されていない患者情報が含まれていることを表す
+// 変換は患者IDをそのまま移すため、匿名化前のファイルは変換しない
+var ErrNotAnonymized = errors.New("input is not anonymized")
+
+// XMLToMWF は HL7 aECG 文書を MWF ファイルに変換する
+// 患者情報は ID、性別、生年月、記録開始日時のみを移す
+// 匿名化済みの文書だけを変換し、氏名や生年月日、仮名でない患者IDが残っている場合は ErrNotAnonymized を返す
+func XMLToMWF(xmlData []byte) ([]byte, error) {
+	ecg, err := xml.ParseECG(xmlData)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAnonymized(ecg.PatientID, ecg.Name, hasBirthDay(ecg.BirthTime)); err != nil {
+		return nil, err
+	}
+
+	w := &mfer.Waveform{SamplingRate: ecg.SamplingRate}
+	for _, lead := range ecg.Leads {
+		ch := mfer.Channel{
+			Lead:       mdcToLead(lead.Code),
+			Resolution: lead.Scale,
+			Raw:        make([]float64, len(lead.Digits)),
+		}
+		if lead.Scale == 0 {
+			return nil, fmt.Errorf("lead %s has zero scale", lead.Code)
+		}
+		// MFER ではゼロレベルを生の値で表す
+		ch.Offset = -lead.Origin / lead.Scale
+		if ch.Offset != math.Trunc(ch.Offset) {
+			return nil, fmt.Errorf("lead %s: origin %v is not a multiple of scale %v", lead.Code, lead.Origin, lead.Scale)
+		}
+		for i, d := range lead.Digits {
+			ch.Raw[i] = float64(d)
+		}
+		w.Channels = append(w.Channels, ch)
+	}
+
+	header := mfer.Header{PatientID: ecg.PatientID}
+	switch ecg.Sex {
+	case "M":
+		header.Sex = 1
+	case "F":
+		header.Sex = 2
+	}
+	if start, err := parseTS(ecg.Start); err == nil {
+		header.Time = start
+	}
+	if year, month, ok := parseBirthMonth(ecg.BirthTime); ok {
+		header.Age = mfer.PatientAge{BirthYear: uint16(year), BirthMonth: uint8(month)}
+		if !header.Time.IsZero() {
+			header.Age.Years = uint8(ageInYears(year, month, header.Time))
+		}
+	}
+
+	f, err := mfer.NewFile(header, w)
+	if err != nil {
+		return nil, err
+	}
+	return f.Encode(), nil
+}
+
+// MWFToXML は MWF ファイルを HL7 aECG 文書に変換する
+// 患者情報は ID、性別、生年月、記録開始日時のみを移す
+// 匿名化済みのファイルだけを変換し、氏名や生年月日、仮名でない患者IDが残っている場合は ErrNotAnonymized を返す
+func MWFToXML(mwfData []byte) ([]byte, error) {
+	f, err := mfer.Parse(mwfData)
+	if err != nil {
+		return nil, err
+	}
+	w, err := f.Waveform()
+	if err != nil {
+		return nil, err
+	}
+
+	ecg := &xml.ECG{SamplingRate: w.SamplingRate}
+	for _, ch := range w.Channels {
+		lead := xml.Lead{
+			Code:   leadToMDC(ch.Lead),
+			Origin: -ch.Offset * ch.Resolution,
+			Scale:  ch.Resolution,
+			Digits: make([]int, len(ch.Raw)),
+		}
+		for i, v := range ch.Raw {
+			if v != math.Trunc(v) {
+				return nil, fmt.Errorf("lead %s: sample %v is not an integer", ch.Lead, v)
+			}
+			lead.Digits[i] = int(v)
+		}
+		ecg.Leads = append(ecg.Leads, lead)
+	}
+
+	order := f.ByteOrder()
+	if tag := f.Find(mfer.P_ID); tag != nil {
+		if ecg.PatientID, err = f.DecodeString(tag.Contents); err != nil {
+			return nil, err
+		}
+		ecg.PatientID = strings.Trim(ecg.PatientID, " \x00")
+	}
+	name := ""
+	if tag := f.Find(mfer.P_NAME); tag != nil {
+		if name, err = f.DecodeString(tag.Contents); err != nil {
+			return nil, err
+		}
+		if name = strings.Trim(name, " \x00"); name == mfer.PlaceholderName {
+			name = ""
+		}
+	}
+	birthDay := false
+	if tag := f.Find(mfer.P_AGE); tag != nil {
+		age, err := mfer.DecodeAge(tag.Contents, order)
+		birthDay = err != nil || age.BirthDay != 0
+	}
+	if err := checkAnonymized(ecg.PatientID, name, birthDay); err != nil {
+		return nil, err
+	}
+	if tag := f.Find(mfer.P_SEX); tag != nil && len(tag.Contents) == 1 {
+		switch tag.Contents[0] {
+		case 1:
+			ecg.Sex = "M"
+		case 2:
+			ecg.Sex = "F"
+		default:
+			ecg.Sex = "UN"
+		}
+	}
+	if tag := f.Find(mfer.P_AGE); tag != nil {
+		if age, err := mfer.DecodeAge(tag.Contents, order); err == nil && age.BirthYear != 0 {
+			ecg.BirthTime = fmt.Sprintf("%04d%02d", age.BirthYear, age.BirthMonth)
+		}
+	}
+	if tag := f.Find(mfer.TIME); tag != nil {
+		if start, err := mfer.DecodeTime(tag.Contents, order); err == nil {
+			ecg.Start = start.Format(tsLayout + ".000")
+		}
+	}
+
+	return ecg.Marshal()
+}
+
+// checkAnonymized は変換する患者情報が匿名化済みかどうかを確かめる
+// 患者IDは空か仮名、氏名は空で、生年月日の日が残っていないことを求める
+func checkAnonymized(patientID, name string, birthDay bool) error {
+	if patientID != "" && !pseudonymID.MatchString(patientID) {
+		return fmt.Errorf("%w: patient ID is not a pseudonym", ErrNotAnonymized)
+	}
+	if strings.TrimSpace(name) != "" {
+		return fmt.Errorf("%w: patient name is present", ErrNotAnonymized)
+	}
+	if birthDay {
+		return fmt.Errorf("%w: birth date includes the day", ErrNotAnonymized)
+	}
+	return nil
+}
+
+// hasBirthDay は birthTime の値が日まで含むかどうかを返す
+func hasBirthDay(value string) bool {
+	if slashBirthTime.MatchString(value) {
+		return false
+	}
+	return len(value) > 6
+}
+
+// mdcToLead は MDC_ECG_LEAD_AVR などの誘導コードを MFER の誘導名(aVR など)に変換する
+func mdcToLead(code string) string {
+	name := strings.TrimPrefix(code, mdcLeadPrefix)
+	switch name {
+	case "AVR", "AVL", "AVF":
+		return "a" + name[1:]
+	}
+	return name
+}
+
+// leadToMDC は MFER の誘導名を HL7 aECG の誘導コードに変換する
+func leadToMDC(name string) string {
+	return mdcLeadPrefix + strings.ToUpper(name)
+}
+
+// parseTS は HL7 TS 形式 (YYYYMMDDHHMMSS.UUUU、後ろは省略可能) の日時を読む
+func parseTS(ts string) (time.Time, error) {
+	ts, frac, _ := strings.Cut(ts, ".")
+	if len(ts) < 8 || len(ts) > len(tsLayout) || len(ts)%2 != 0 {
+		return time.Time{}, fmt.Errorf("invalid HL7 TS %q", ts)
+	}
+	t, err := time.Parse(tsLayout[:len(ts)], ts)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if frac != "" {
+		sec, err := strconv.ParseFloat("0."+frac, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		t = t.Add(time.Duration(sec * float64(time.Second)))
+	}
+	return t, nil
+}
+
+// parseBirthMonth は birthTime の値から生年と月を読む
+// HL7 TS 形式と以前の xml.Anonymize が書いていた YYYY/M 形式に対応する
+func parseBirthMonth(value string) (int, int, bool) {
+	if m := slashBirthTime.FindStringSubmatch(value); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		return year, month, true
+	}
+	if len(value) >= 6 {
+		year, err1 := strconv.Atoi(value[:4])
+		month, err2 := strconv.Atoi(value[4:6])
+		if err1 == nil && err2 == nil {
+			return year, month, true
+		}
+	}
+	return 0, 0, false
+}
+
+// ageInYears は生年月と記録日から満年齢を求める (生まれた日は分からないため月単位で数える)
+func ageInYears(year, month int, at time.Time) int {
+	age := at.Year() - year
+	if int(at.Month()) < month {
+		age--
+	}
+	if age < 0 {
+		return 0
+	}
+	return age
+}
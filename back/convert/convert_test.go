@@ -0,0 +1,234 @@
+package convert
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shikidalab/anonymize-ecg/mfer"
+	"github.com/shikidalab/anonymize-ecg/xml"
+)
+
+// testPseudonym は匿名化で書かれる形式の患者ID
+const testPseudonym = "v10123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// テスト用の HL7 aECG 文書 (匿名化済み)
+var testXML = []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<AnnotatedECG xmlns="urn:hl7-org:v3" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <subject>
+    <patient>
+      <patientPatient>
+        <id extension="v10123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"/>
+        <administrativeGenderCode code="F"/>
+        <birthTime value="1984/11"/>
+      </patientPatient>
+    </patient>
+  </subject>
+  <component>
+    <series>
+      <component>
+        <sequenceSet>
+          <component>
+            <sequence>
+              <code code="TIME_ABSOLUTE"/>
+              <value xsi:type="GLIST_TS">
+                <head value="20070301102030.000"/>
+                <increment value="2" unit="ms"/>
+              </value>
+            </sequence>
+          </component>
+          <component>
+            <sequence>
+              <code code="MDC_ECG_LEAD_I"/>
+              <value xsi:type="SLIST_PQ">
+                <origin value="0" unit="uV"/>
+                <scale value="5" unit="uV"/>
+                <digits>1 -2 3</digits>
+              </value>
+            </sequence>
+          </component>
+          <component>
+            <sequence>
+              <code code="MDC_ECG_LEAD_AVR"/>
+              <value xsi:type="SLIST_PQ">
+                <origin value="-0.01" unit="mV"/>
+                <scale value="5" unit="uV"/>
+                <digits>4 5 6</digits>
+              </value>
+            </sequence>
+          </component>
+        </sequenceSet>
+      </component>
+    </series>
+  </component>
+</AnnotatedECG>`)
+
+func TestXMLToMWF(t *testing.T) {
+	mwf, err := XMLToMWF(testXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := mfer.Parse(mwf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := f.Waveform()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.SamplingRate != 500 {
+		t.Errorf("unexpected sampling rate, got: %v, want: %v", w.SamplingRate, 500)
+	}
+	expected := []struct {
+		lead    string
+		samples []float64
+	}{
+		{"I", []float64{5, -10, 15}},
+		{"aVR", []float64{10, 15, 20}},
+	}
+	for i, want := range expected {
+		ch := w.Channels[i]
+		if ch.Lead != want.lead {
+			t.Errorf("unexpected lead, got: %s, want: %s", ch.Lead, want.lead)
+		}
+		for n, v := range want.samples {
+			if ch.Samples[n] != v {
+				t.Errorf("unexpected sample %d of %s, got: %v, want: %v", n, ch.Lead, ch.Samples[n], v)
+			}
+		}
+	}
+
+	if id, _ := f.DecodeString(f.Find(mfer.P_ID).Contents); id != testPseudonym {
+		t.Errorf("unexpected patient ID, got: %s, want: %s", id, testPseudonym)
+	}
+	age, err := mfer.DecodeAge(f.Find(mfer.P_AGE).Contents, f.ByteOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if age.Years != 22 || age.BirthYear != 1984 || age.BirthMonth != 11 || age.BirthDay != 0 {
+		t.Errorf("unexpected age: %+v", age)
+	}
+	start, err := mfer.DecodeTime(f.Find(mfer.TIME).Contents, f.ByteOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2007, 3, 1, 10, 20, 30, 0, time.UTC); !start.Equal(want) {
+		t.Errorf("unexpected time, got: %v, want: %v", start, want)
+	}
+}
+
+func TestMWFToXMLRoundTrip(t *testing.T) {
+	mwf, err := XMLToMWF(testXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, err := MWFToXML(mwf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := xml.ParseECG(testXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := xml.ParseECG(converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.PatientID != testPseudonym || got.Sex != "F" || got.BirthTime != "198411" || got.Start != "20070301102030.000" {
+		t.Errorf("unexpected patient block: %+v", got)
+	}
+	if got.SamplingRate != want.SamplingRate || len(got.Leads) != len(want.Leads) {
+		t.Fatalf("unexpected waveform, got: %v Hz %d leads", got.SamplingRate, len(got.Leads))
+	}
+	for i, lead := range want.Leads {
+		g := got.Leads[i]
+		if g.Code != lead.Code || g.Origin != lead.Origin || g.Scale != lead.Scale {
+			t.Errorf("unexpected lead, got: %+v, want: %+v", g, lead)
+		}
+		for n := range lead.Digits {
+			if g.Digits[n] != lead.Digits[n] {
+				t.Errorf("unexpected digit %d of %s, got: %d, want: %d", n, lead.Code, g.Digits[n], lead.Digits[n])
+			}
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	mwf, err := XMLToMWF(testXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Compare(testXML, mwf, 0); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	f, err := mfer.Parse(mwf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := f.Waveform()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Channels[1].Samples[2] += 5
+
+	aecg, err := xml.ParseAnnotatedECG(testXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CompareSeries(aecg.Rhythm(), w, 1); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected ErrMismatch, got: %v", err)
+	}
+}
+
+func TestNotAnonymized(t *testing.T) {
+	// 匿名化前の患者情報が残っている文書は変換しない
+	for name, replace := range map[string][2]string{
+		"patient ID": {testPseudonym, "PID-1001"},
+		"name":       {`<administrativeGenderCode`, `<name><given>Taro</given><family>Yamada</family></name><administrativeGenderCode`},
+		"birth date": {`<birthTime value="1984/11"/>`, `<birthTime value="19841123"/>`},
+	} {
+		data := []byte(strings.Replace(string(testXML), replace[0], replace[1], 1))
+		if _, err := XMLToMWF(data); !errors.Is(err, ErrNotAnonymized) {
+			t.Errorf("%s: expected ErrNotAnonymized from XMLToMWF, got: %v", name, err)
+		}
+	}
+
+	w := &mfer.Waveform{
+		SamplingRate: 500,
+		Channels:     []mfer.Channel{{Lead: "I", Resolution: 5, Raw: []float64{1, 2, 3}}},
+	}
+	newMWF := func(header mfer.Header, name string) []byte {
+		f, err := mfer.NewFile(header, w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != "" {
+			contents, err := f.EncodeString(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Insert(mfer.NewTag(mfer.P_NAME, contents))
+		}
+		return f.Encode()
+	}
+	for name, mwf := range map[string][]byte{
+		"patient ID": newMWF(mfer.Header{PatientID: "11237000051"}, ""),
+		"name":       newMWF(mfer.Header{PatientID: testPseudonym}, "Yamada Taro"),
+		"birth date": newMWF(mfer.Header{PatientID: testPseudonym, Age: mfer.PatientAge{Years: 22, BirthYear: 1984, BirthMonth: 11, BirthDay: 23}}, ""),
+	} {
+		if _, err := MWFToXML(mwf); !errors.Is(err, ErrNotAnonymized) {
+			t.Errorf("%s: expected ErrNotAnonymized from MWFToXML, got: %v", name, err)
+		}
+	}
+
+	// 仮名に置き換えた氏名は匿名化済みとみなす
+	if _, err := MWFToXML(newMWF(mfer.Header{PatientID: testPseudonym}, mfer.PlaceholderName)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
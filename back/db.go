@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/shikidalab/anonymize-ecg/model"
+)
+
+// runDBMigrate は db migrate サブコマンドを実行する
+func runDBMigrate(args []string) error {
+	s := newSettings("db migrate", "db migrate [flags]")
+	dsn := s.secret("dsn", "DSN", "SQLite database file")
+	if err := s.parse(args); err != nil {
+		return err
+	}
+	if err := model.SetupDB(*dsn); err != nil {
+		return err
+	}
+	fmt.Printf("database is up to date: %s\n", *dsn)
+	return nil
+}
+
+// runDBBackup は db backup サブコマンドを実行する
+func runDBBackup(args []string) error {
+	s := newSettings("db backup", "db backup -out FILE [flags]")
+	dsn := s.secret("dsn", "DSN", "SQLite database file")
+	out := s.flags.String("out", "", "backup file to create (must not exist)")
+	if err := s.parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		s.flags.Usage()
+		return errors.New("-out is required")
+	}
+	if _, err := os.Stat(*dsn); err != nil {
+		return fmt.Errorf("database %s: %w", *dsn, err)
+	}
+
+	db, err := model.GetDB(*dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := model.Backup(db, *out); err != nil {
+		return err
+	}
+	fmt.Printf("database was backed up to: %s\n", *out)
+	return nil
+}
@@ -0,0 +1,201 @@
+package edf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shikidalab/anonymize-ecg/mfer"
+)
+
+const (
+	digitalMin = math.MinInt16
+	digitalMax = math.MaxInt16
+
+	// annotationSamples はデータレコードごとの EDF Annotations 信号のサンプル数(2バイト単位)
+	annotationSamples = 8
+)
+
+var (
+	ErrNoChannels           = errors.New("waveform has no channels")
+	ErrUnsupportedFrequency = errors.New("sampling rate must be a whole number of Hz")
+)
+
+// Header は EDF+ ヘッダに書く情報
+// 識別につながる情報を書かないよう、患者には仮名IDだけを、記録には開始日時だけを書く
+type Header struct {
+	PatientID string    // 仮名ID
+	Start     time.Time // 日付をずらした記録開始日時 (ゼロ値の場合は不明として扱う)
+}
+
+// Write は MFER の波形から EDF+C ファイルを作る
+// データレコードの長さは1秒で、最後のレコードの足りないサンプルは0で埋める
+func Write(header Header, w *mfer.Waveform) ([]byte, error) {
+	if len(w.Channels) == 0 {
+		return nil, ErrNoChannels
+	}
+	fs := math.Round(w.SamplingRate)
+	if fs <= 0 || math.Abs(w.SamplingRate-fs) > 1e-6 {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFrequency, w.SamplingRate)
+	}
+	perRecord := int(fs)
+
+	nsamp := len(w.Channels[0].Samples)
+	signals := make([]signal, len(w.Channels))
+	for i, ch := range w.Channels {
+		if len(ch.Samples) != nsamp {
+			return nil, fmt.Errorf("channel %s has %d samples, expected %d", ch.Lead, len(ch.Samples), nsamp)
+		}
+		signals[i] = newSignal(ch)
+	}
+	records := (nsamp + perRecord - 1) / perRecord
+
+	var buf bytes.Buffer
+	writeHeader(&buf, header, signals, records, perRecord)
+
+	for r := 0; r < records; r++ {
+		for _, s := range signals {
+			for n := r * perRecord; n < (r+1)*perRecord; n++ {
+				var v int16
+				if n < nsamp {
+					v = s.digital[n]
+				}
+				binary.Write(&buf, binary.LittleEndian, v)
+			}
+		}
+		// レコードの開始時刻を表す TAL
+		tal := make([]byte, annotationSamples*2)
+		copy(tal, fmt.Sprintf("+%d\x14\x14\x00", r))
+		buf.Write(tal)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeHeader(buf *bytes.Buffer, header Header, signals []signal, records, perRecord int) {
+	patient := strings.Join([]string{field(header.PatientID), "X", "X", "X"}, " ")
+	recording := "Startdate X X X X"
+	start := time.Date(1985, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !header.Start.IsZero() {
+		start = header.Start
+		recording = fmt.Sprintf("Startdate %s X X X", strings.ToUpper(start.Format("02-Jan-2006")))
+	}
+
+	ns := len(signals) + 1
+	writeField(buf, "0", 8)
+	writeField(buf, patient, 80)
+	writeField(buf, recording, 80)
+	writeField(buf, start.Format("02.01.06"), 8)
+	writeField(buf, start.Format("15.04.05"), 8)
+	writeField(buf, strconv.Itoa(256*(ns+1)), 8)
+	writeField(buf, "EDF+C", 44)
+	writeField(buf, strconv.Itoa(records), 8)
+	writeField(buf, "1", 8)
+	writeField(buf, strconv.Itoa(ns), 4)
+
+	// 信号ごとの項目は項目ごとにまとめて書き、最後に EDF Annotations 信号を置く
+	each := func(size int, value func(s signal) string, annotation string) {
+		for _, s := range signals {
+			writeField(buf, value(s), size)
+		}
+		writeField(buf, annotation, size)
+	}
+	each(16, func(s signal) string { return s.label }, "EDF Annotations")
+	each(80, func(s signal) string { return "" }, "")
+	each(8, func(s signal) string { return s.dimension }, "")
+	each(8, func(s signal) string { return formatNumber(s.physicalMin) }, "-1")
+	each(8, func(s signal) string { return formatNumber(s.physicalMax) }, "1")
+	each(8, func(s signal) string { return strconv.Itoa(digitalMin) }, strconv.Itoa(digitalMin))
+	each(8, func(s signal) string { return strconv.Itoa(digitalMax) }, strconv.Itoa(digitalMax))
+	each(80, func(s signal) string { return "" }, "")
+	each(8, func(s signal) string { return strconv.Itoa(perRecord) }, strconv.Itoa(annotationSamples))
+	each(32, func(s signal) string { return "" }, "")
+}
+
+// signal は EDF の1信号分の設定と値
+type signal struct {
+	label       string
+	dimension   string
+	physicalMin float64
+	physicalMax float64
+	digital     []int16
+}
+
+// newSignal はチャネルを EDF の信号に変換する
+// 物理量の絶対値の最大がデジタル値の範囲に収まるように分解能を決める
+func newSignal(ch mfer.Channel) signal {
+	s := signal{label: ch.Lead, digital: make([]int16, len(ch.Samples))}
+	if ch.Unit == 0 {
+		s.label = "ECG " + ch.Lead
+		s.dimension = "uV"
+	}
+
+	peak := 0.0
+	for _, v := range ch.Samples {
+		if !math.IsNaN(v) {
+			peak = math.Max(peak, math.Abs(v))
+		}
+	}
+	if peak == 0 {
+		peak = 1
+	}
+
+	// ヘッダの8文字に収まる値に丸めてから分解能を決める
+	max, _ := strconv.ParseFloat(formatNumber(math.Ceil(peak)), 64)
+	s.physicalMax = max
+	s.physicalMin = -max
+	resolution := (s.physicalMax - s.physicalMin) / (digitalMax - digitalMin)
+
+	for n, v := range ch.Samples {
+		if math.IsNaN(v) {
+			s.digital[n] = 0
+			continue
+		}
+		d := math.Round((v-s.physicalMin)/resolution) + digitalMin
+		s.digital[n] = int16(math.Max(digitalMin, math.Min(digitalMax, d)))
+	}
+	return s
+}
+
+// field はヘッダの項目内で区切り文字として使われる空白を取り除く
+func field(s string) string {
+	if s == "" {
+		return "X"
+	}
+	return strings.ReplaceAll(s, " ", "_")
+}
+
+// writeField は size バイトに満たない部分を空白で埋めて書く
+// EDF のヘッダは印字可能な ASCII 文字のみを使う
+func writeField(buf *bytes.Buffer, value string, size int) {
+	b := make([]byte, 0, size)
+	for _, r := range value {
+		if len(b) == size {
+			break
+		}
+		if r < 0x20 || r > 0x7e {
+			r = '_'
+		}
+		b = append(b, byte(r))
+	}
+	buf.Write(b)
+	buf.Write(bytes.Repeat([]byte{' '}, size-len(b)))
+}
+
+// formatNumber は数値を8文字以内の文字列にする
+func formatNumber(v float64) string {
+	for prec := 3; prec >= 0; prec-- {
+		s := strconv.FormatFloat(v, 'f', prec, 64)
+		if strings.Contains(s, ".") {
+			s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+		}
+		if len(s) <= 8 {
+			return s
+		}
+	}
+	return strconv.FormatFloat(v, 'g', 3, 64)
+}
@@ -0,0 +1,71 @@
+package edf
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shikidalab/anonymize-ecg/mfer"
+)
+
+func TestWrite(t *testing.T) {
+	// 2Hz, 1誘導, 3サンプル (2レコードになる)
+	w := &mfer.Waveform{
+		SamplingRate: 2,
+		Channels: []mfer.Channel{
+			{Lead: "II", Resolution: 1, Raw: []float64{-100, 0, 100}, Samples: []float64{-100, 0, 100}},
+		},
+	}
+	header := Header{
+		PatientID: "abcd",
+		Start:     time.Date(2023, 12, 30, 9, 8, 7, 0, time.UTC),
+	}
+
+	got, err := Write(header, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ヘッダ 256 * (信号数 + 1) バイトと、2レコード分の (2サンプル + 注釈 8サンプル) * 2バイト
+	if len(got) != 256*3+2*(2+8)*2 {
+		t.Fatalf("unexpected size: %d", len(got))
+	}
+
+	fields := []struct {
+		name   string
+		offset int
+		size   int
+		want   string
+	}{
+		{"patient", 8, 80, "abcd X X X"},
+		{"recording", 88, 80, "Startdate 30-DEC-2023 X X X"},
+		{"startdate", 168, 8, "30.12.23"},
+		{"starttime", 176, 8, "09.08.07"},
+		{"header bytes", 184, 8, "768"},
+		{"reserved", 192, 44, "EDF+C"},
+		{"records", 236, 8, "2"},
+		{"duration", 244, 8, "1"},
+		{"signals", 252, 4, "2"},
+		{"label", 256, 16, "ECG II"},
+		{"annotation label", 272, 16, "EDF Annotations"},
+		{"physical dimension", 256 + 2*96, 8, "uV"},
+		{"physical minimum", 256 + 2*104, 8, "-100"},
+		{"physical maximum", 256 + 2*112, 8, "100"},
+		{"samples per record", 256 + 2*216, 8, "2"},
+	}
+	for _, f := range fields {
+		if v := strings.TrimRight(string(got[f.offset:f.offset+f.size]), " "); v != f.want {
+			t.Errorf("unexpected %s, got: %q, want: %q", f.name, v, f.want)
+		}
+	}
+
+	// 最初のレコードは II の2サンプルと時刻 0 の TAL
+	record := got[768:]
+	if v := int16(binary.LittleEndian.Uint16(record[0:2])); v != -32768 {
+		t.Errorf("unexpected first sample, got: %d, want: %d", v, -32768)
+	}
+	if tal := string(record[4:9]); tal != "+0\x14\x14\x00" {
+		t.Errorf("unexpected TAL, got: %q", tal)
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"github.com/shikidalab/anonymize-ecg/controller"
+)
+
+// runExport は export サブコマンドを実行する
+func runExport(args []string) error {
+	s := newSettings("export", "export [flags]")
+	s.secret("dsn", "DSN", "SQLite database file")
+	s.env("save-dir", "SAVE_DIR", "directory where the CSV file is saved")
+	if err := s.parse(args); err != nil {
+		return err
+	}
+	return controller.SaveCSVFile()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shikidalab/anonymize-ecg/mfer"
+	"github.com/shikidalab/anonymize-ecg/xml"
+)
+
+// redacted は -redact を指定した場合に識別情報の代わりに表示する文字列
+const redacted = "[REDACTED]"
+
+// runInspect は inspect サブコマンドを実行する
+func runInspect(args []string) error {
+	s := newSettings("inspect", "inspect [-redact] FILE...")
+	redact := s.flags.Bool("redact", false, "hide patient, date and device identifiers so the output can be shared")
+	if err := s.parse(args); err != nil {
+		return err
+	}
+	if s.flags.NArg() == 0 {
+		s.flags.Usage()
+		return errors.New("no files to inspect")
+	}
+
+	for _, path := range s.flags.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name := path
+		if *redact {
+			name = filepath.Ext(path)
+		}
+		fmt.Printf("== %s (%d bytes)\n", name, len(data))
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".mwf":
+			err = inspectMWF(os.Stdout, data, *redact)
+		case ".xml":
+			err = inspectXML(os.Stdout, data, *redact)
+		default:
+			err = fmt.Errorf("unsupported file type: %s", path)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// inspectMWF はタグの一覧を表示する
+// 子タグは字下げして表示し、CHANNEL_ATTRIBUTE にはチャネル番号を付ける
+func inspectMWF(w io.Writer, data []byte, redact bool) error {
+	f, err := mfer.Parse(data)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%-8s %-8s %s\n", "OFFSET", "LENGTH", "TAG")
+	var print func(tags []*mfer.Tag, depth int)
+	print = func(tags []*mfer.Tag, depth int) {
+		for _, tag := range tags {
+			name := fmt.Sprintf("%s%s (0x%02x)", strings.Repeat("  ", depth), mfer.TagName(tag.Code), tag.Code)
+			if tag.Code == mfer.CHANNEL_ATTRIBUTE {
+				name += fmt.Sprintf(" channel %d", tag.Channel)
+			}
+			value := f.Describe(tag)
+			if redact && mfer.IsIdentifying(tag.Code) && len(tag.Contents) > 0 {
+				value = redacted
+			}
+			fmt.Fprintln(w, strings.TrimSpace(fmt.Sprintf("%-8d %-8d %s %s", tag.Offset, len(tag.Contents), name, value)))
+			print(tag.Children, depth+1)
+		}
+	}
+	print(f.Tags, 0)
+	if len(f.Trailer) > 0 {
+		fmt.Fprintf(w, "trailer: %d bytes after END\n", len(f.Trailer))
+	}
+	return nil
+}
+
+// inspectXML は形式、患者と記録の情報、波形の概要を表示する
+func inspectXML(w io.Writer, data []byte, redact bool) error {
+	info, err := xml.GetPersonalInfo(data)
+	if err != nil && !errors.Is(err, xml.ErrNoPatientID) {
+		return err
+	}
+	fmt.Fprintf(w, "dialect: %s\n", info.Dialect)
+
+	// 性別以外は識別につながりうる
+	items := []struct {
+		field xml.Field
+		value string
+	}{
+		{xml.FieldECGID, info.ECGID},
+		{xml.FieldPatientID, info.PatientID},
+		{xml.FieldName, info.Name},
+		{xml.FieldGivenName, info.GivenName},
+		{xml.FieldBirthTime, info.BirthTime},
+		{xml.FieldSex, info.Sex},
+		{xml.FieldAcquisitionTime, info.AcquisitionTime},
+		{xml.FieldDevice, info.Device},
+	}
+	for _, item := range items {
+		value := "(not found)"
+		if info.Found(item.field) {
+			value = fmt.Sprintf("%q", item.value)
+			if redact && item.field != xml.FieldSex && item.value != "" {
+				value = redacted
+			}
+		}
+		fmt.Fprintf(w, "%s: %s\n", item.field, value)
+	}
+
+	// 波形は HL7 aECG の形式の文書のみ読める
+	aecg, err := xml.ParseAnnotatedECG(data)
+	if err != nil {
+		fmt.Fprintf(w, "waveform: %v\n", err)
+		return nil
+	}
+	for _, series := range aecg.Series {
+		kind := "series"
+		if series.Derived {
+			kind = "derivedSeries"
+		}
+		code := series.Code
+		if code == "" {
+			code = "(no code)"
+		}
+		samples := 0
+		if len(series.Leads) > 0 {
+			samples = len(series.Leads[0].Digits)
+		}
+		fmt.Fprintf(w, "%s %s: %g Hz, %d leads, %d samples\n", kind, code, series.SamplingRate, len(series.Leads), samples)
+		for _, lead := range series.Leads {
+			fmt.Fprintf(w, "  %s: origin %g uV, scale %g uV, %d samples\n", lead.Code, lead.Origin, lead.Scale, len(lead.Digits))
+		}
+		if err := series.Validate(); err != nil {
+			fmt.Fprintf(w, "  invalid: %v\n", err)
+		}
+	}
+	return nil
+}
@@ -1,29 +1,39 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"time"
 
-	"github.com/gin-contrib/cors"
-	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/shikidalab/anonymize-ecg/controller"
-	"github.com/shikidalab/anonymize-ecg/model"
 )
 
 func main() {
+	global := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	envFile := global.String("env", "../.env", "path to the .env file (optional)")
+	logDir := global.String("log-dir", "log", "directory for log files")
+	// 以前の `-export` オプションは export サブコマンドとして扱う
+	export := global.Bool("export", false, "same as the export command (deprecated)")
+	global.Usage = func() {
+		printCommands(global.Output())
+		fmt.Fprintf(global.Output(), "\nGlobal flags:\n")
+		global.PrintDefaults()
+	}
+	global.Parse(os.Args[1:])
+
 	// ログファイルの設定
-	if err := os.MkdirAll("log", os.ModePerm); err != nil {
+	if err := os.MkdirAll(*logDir, os.ModePerm); err != nil {
 		log.Fatalf("Failed to create log directory: %v", err)
 	}
 
 	loc, _ := time.LoadLocation("Asia/Tokyo")
-	logFileName := fmt.Sprintf("log/%s.log", time.Now().In(loc).Format("2006-01-02")) // 実行した日付のログファイルをつくる
+	logFileName := filepath.Join(*logDir, fmt.Sprintf("%s.log", time.Now().In(loc).Format("2006-01-02"))) // 実行した日付のログファイルをつくる
 
 	f, err := os.OpenFile(logFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -32,59 +42,41 @@ func main() {
 	defer f.Close()
 
 	// 標準出力とファイルに同時にログを書き込むためのMultiWriterを作成
-	multiWriter := io.MultiWriter(os.Stdout, f)
+	logWriter = io.MultiWriter(os.Stdout, f)
 
 	// logをstdoutとログファイルの両方に出す
-	log.SetOutput(multiWriter)
+	log.SetOutput(logWriter)
 	log.Println("main function was started")
 
 	// .envファイルを読み込む
-	err = godotenv.Load("../.env")
-	if err != nil {
-		log.Fatalf("Error loading .env file")
-	}
-
-	// dbの立ち上げ
-	dsn := os.Getenv("DSN")
-	err = model.SetupDB(dsn)
-	if err != nil {
-		log.Fatal(err)
+	// ファイルがなくても環境変数やフラグで設定できるので、-env を指定しなかった場合はエラーにしない
+	if err := godotenv.Load(*envFile); err != nil {
+		explicit := false
+		global.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "env" })
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			log.Fatalf("Error loading .env file: %v", err)
+		}
 	}
 
-	// `-export` オプションを定義
-	export := flag.Bool("export", false, "Export the data")
-
-	// `-export` が指定された場合はcsvに吐き出して終了
-	flag.Parse()
+	args := global.Args()
 	if *export {
-		err := controller.SaveCSVFile()
-		if err != nil {
-			log.Fatalf("Error saving CSV file: %v", err)
-		}
+		args = append([]string{"export"}, args...)
+	}
+	if len(args) > 0 && args[0] == "help" {
+		printCommands(os.Stdout)
 		return
 	}
 
-	// ginのログ出力先をstdoutとlogファイルの両方に指定
-	gin.DefaultWriter = multiWriter
-	gin.DefaultErrorWriter = multiWriter
-
-	// httpサーバのセットアップ
-	router := gin.Default()
-
-	router.Use(gin.Logger())
-	router.Use(gin.Recovery())
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{os.Getenv("FRONT_ORIGIN")}, // フロントエンドのオリジン
-		AllowMethods:     []string{"GET", "POST"},
-		AllowHeaders:     []string{"Origin", "Content-Type"},
-		AllowCredentials: true,
-	}))
-
-	// サーバのルーティング設定
-	router.GET("/", controller.GetTop)
-	router.GET("/upload", controller.AnonymizeECG)
-	router.GET("/download-csv", controller.ExportCSV)
-
-	// サーバの起動
-	router.Run()
+	// サブコマンドがない場合はサーバを起動する
+	cmd, rest := findCommand(args)
+	if cmd == nil {
+		if len(args) > 0 {
+			printCommands(os.Stderr)
+			log.Fatalf("unknown command: %s", args[0])
+		}
+		cmd, rest = findCommand([]string{"serve"})
+	}
+	if err := cmd.run(rest); err != nil {
+		log.Fatalf("Error in %s: %v", cmd.name, err)
+	}
 }
@@ -0,0 +1,64 @@
+package mfer
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// PatientAge は P_AGE タグの内容を表す
+// 年齢(年) 1バイト, 日齢 2バイト, 生年月日(年 2バイト, 月 1バイト, 日 1バイト) の順に並ぶ
+type PatientAge struct {
+	Years      uint8
+	Days       uint16
+	BirthYear  uint16
+	BirthMonth uint8
+	BirthDay   uint8
+
+	size int // 元の内容のバイト数 (1, 3, 7)
+}
+
+// DecodeAge は P_AGE タグの内容を読む
+func DecodeAge(contents []byte, order binary.ByteOrder) (PatientAge, error) {
+	age := PatientAge{size: len(contents)}
+	switch len(contents) {
+	case 7:
+		age.BirthYear = order.Uint16(contents[3:5])
+		age.BirthMonth = contents[5]
+		age.BirthDay = contents[6]
+		fallthrough
+	case 3:
+		age.Days = order.Uint16(contents[1:3])
+		fallthrough
+	case 1:
+		age.Years = contents[0]
+	default:
+		return PatientAge{}, fmt.Errorf("%w: P_AGE length %d", ErrBadLength, len(contents))
+	}
+	return age, nil
+}
+
+// Encode は P_AGE タグの内容を元と同じバイト数で書く
+func (a PatientAge) Encode(order binary.ByteOrder) []byte {
+	size := a.size
+	if size == 0 {
+		size = 7
+	}
+	b := make([]byte, size)
+	b[0] = a.Years
+	if size >= 3 {
+		order.PutUint16(b[1:3], a.Days)
+	}
+	if size >= 7 {
+		order.PutUint16(b[3:5], a.BirthYear)
+		b[5] = a.BirthMonth
+		b[6] = a.BirthDay
+	}
+	return b
+}
+
+// Generalize は日齢と生年月日の日を0にし、年齢(年)と生年月だけを残す
+func (a PatientAge) Generalize() PatientAge {
+	a.Days = 0
+	a.BirthDay = 0
+	return a
+}
@@ -1,65 +1,117 @@
 package mfer
 
-import (
-	"encoding/binary"
-	"errors"
-	"fmt"
-)
+import "encoding/binary"
+
+// PlaceholderName は患者名を置き換える場合に書き込む固定値
+const PlaceholderName = "ANONYMOUS"
+
+// Options は匿名化の設定
+type Options struct {
+	// 空でなければ P_ID を削除せずにこの値(仮名ID)で置き換え、P_NAME を PlaceholderName で置き換える
+	PseudonymID string
+	// TIME タグの日付をずらす日数
+	DateShiftDays int
+	// 自由記述タグの扱い方 (nil の場合は DefaultTextActions)
+	TextActions map[byte]TextAction
+	// TextPseudonymize で仮名の値を作るための鍵
+	PseudonymKey []byte
+}
 
 func Anonymize(bytes []byte) ([]byte, error) {
-	var (
-		tagCode byte
-		length  uint32
-	)
+	return AnonymizeWithOptions(bytes, Options{})
+}
 
-	for i := 0; i < len(bytes); {
-		tagCode = bytes[i]
-		i++
-		if tagCode == ZERO {
-			continue
-		} else if tagCode == END {
-			break
-		}
+func AnonymizeWithOptions(bytes []byte, opts Options) ([]byte, error) {
+	f, err := Parse(bytes)
+	if err != nil {
+		return bytes, err
+	}
 
-		length = uint32(bytes[i])
-		i++
+	order := f.ByteOrder()
 
-		if length > 0x7f { /* MSBが1ならば */
-			numBytes := length - 0x80
-			if numBytes > 4 {
-				fmt.Println("byets = ", bytes[i-2:i+15])
-				fmt.Printf("length = %x, numBytes = %d, bytes = %d\n", length, numBytes, bytes[i-1])
-				return bytes, errors.New("error nbytes")
-			}
-			length = binary.BigEndian.Uint32(append(make([]byte, 4-numBytes), bytes[i:i+int(numBytes)]...))
-			i += int(numBytes)
+	// about patient
+	if opts.PseudonymID != "" {
+		id, err := f.EncodeString(opts.PseudonymID)
+		if err != nil {
+			return bytes, err
+		}
+		name, err := f.EncodeString(PlaceholderName)
+		if err != nil {
+			return bytes, err
 		}
+		replaceAll(f, P_ID, id)
+		replaceAll(f, P_NAME, name)
+	} else {
+		f.Remove(P_NAME)
+		f.Remove(P_ID)
+	}
+	generalizeAge(f, order)
+	// P_SEX: do nothing
 
-		switch tagCode {
-		case CHANNEL_ATTRIBUTE:
-			length = uint32(bytes[i])
-			i++
+	// 内容が分からない機器独自のタグは患者情報を含むことがあるため削除する
+	f.Tags = removeUnknown(f.Tags)
 
-		// about patient
-		case P_NAME:
-			bytes = append(bytes[:i-2], bytes[i+int(length):]...)
-			i -= 2
-			continue
+	textActions := opts.TextActions
+	if textActions == nil {
+		textActions = DefaultTextActions
+	}
+	scrubText(f, textActions, opts.PseudonymKey)
 
-		case P_ID:
-			bytes = append(bytes[:i-2], bytes[i+int(length):]...)
-			i -= 2
-			continue
+	if opts.DateShiftDays != 0 {
+		shiftTimes(f, opts.DateShiftDays, order)
+	}
+
+	return f.Encode(), nil
+}
 
-		case P_AGE:
-			bytes = append(bytes[:i-2], bytes[i+int(length):]...)
-			i -= 2
+// removeUnknown は定義されていないタグを子タグも含めて取り除く
+func removeUnknown(tags []*Tag) []*Tag {
+	kept := tags[:0]
+	for _, tag := range tags {
+		if tag.Category() == CategoryUnknown {
 			continue
+		}
+		if tag.IsContainer() {
+			tag.Children = removeUnknown(tag.Children)
+		}
+		kept = append(kept, tag)
+	}
+	return kept
+}
+
+// replaceAll は code に一致するすべてのタグの内容を置き換える
+// 一致するタグがない場合は新しく追加する
+func replaceAll(f *File, code byte, contents []byte) {
+	found := false
+	f.Walk(func(tag *Tag) bool {
+		if tag.Code == code {
+			tag.Contents = contents
+			found = true
+		}
+		return true
+	})
+	if !found {
+		f.Insert(NewTag(code, contents))
+	}
+}
 
-		case P_SEX:
-			// do nothing
+// generalizeAge は P_AGE を年齢(年)と生年月だけに丸める
+// 内容を解釈できない P_AGE はタグごと削除する
+func generalizeAge(f *File, order binary.ByteOrder) {
+	invalid := false
+	f.Walk(func(tag *Tag) bool {
+		if tag.Code != P_AGE {
+			return true
+		}
+		age, err := DecodeAge(tag.Contents, order)
+		if err != nil {
+			invalid = true
+			return true
 		}
-		i += int(length)
+		tag.Contents = age.Generalize().Encode(order)
+		return true
+	})
+	if invalid {
+		f.Remove(P_AGE)
 	}
-	return bytes, nil
 }
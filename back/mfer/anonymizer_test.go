@@ -41,7 +41,7 @@ func TestAnonymize(t *testing.T) {
 		// 患者ID
 		// 0x82, 0x0b, 0x31, 0x31, 0x32, 0x33, 0x37, 0x30, 0x30, 0x30, 0x35, 0x31, 0x00,
 		// 年齢・生年月日
-		// 0x83, 0x07, 0x16, 0x00, 0x00, 0xc0, 0x07, 0x0b, 0x00,
+		0x83, 0x07, 0x16, 0x00, 0x00, 0xc0, 0x07, 0x0b, 0x00,
 	}
 
 	got, err := Anonymize(testData)
@@ -54,3 +54,178 @@ func TestAnonymize(t *testing.T) {
 		t.Fatalf("expected: %v, got %v", expectedData, got)
 	}
 }
+
+func TestAnonymizeWithPseudonym(t *testing.T) {
+	// テストデータ
+	testData := []byte{
+		// 患者名
+		0x81, 0x03, 0x41, 0x42, 0x43,
+		// 患者ID
+		0x82, 0x03, 0x31, 0x32, 0x33,
+		// 性別
+		0x84, 0x01, 0x01,
+		// 終了
+		0x80,
+	}
+
+	/*
+	 * 関数の返り値として期待されるデータ
+	 * - 患者名が固定値に置き換わる
+	 * - 患者IDが仮名IDに置き換わる
+	 */
+	expectedData := []byte{
+		// 患者名
+		0x81, 0x09, 0x41, 0x4e, 0x4f, 0x4e, 0x59, 0x4d, 0x4f, 0x55, 0x53,
+		// 患者ID
+		0x82, 0x04, 0x61, 0x62, 0x63, 0x64,
+		// 性別
+		0x84, 0x01, 0x01,
+		// 終了
+		0x80,
+	}
+
+	got, err := AnonymizeWithOptions(testData, Options{PseudonymID: "abcd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// テストの判定
+	if !bytes.Equal(expectedData, got) {
+		t.Fatalf("expected: %v, got %v", expectedData, got)
+	}
+}
+
+func TestAnonymizeDateShift(t *testing.T) {
+	// テストデータ
+	testData := []byte{
+		// 測定日時 2024/03/01 10:20:30
+		0x85, 0x07, 0x07, 0xe8, 0x03, 0x01, 0x0a, 0x14, 0x1e,
+		// 終了
+		0x80,
+	}
+
+	/*
+	 * 関数の返り値として期待されるデータ
+	 * - 測定日時が2日前(2024/02/28)にずれる
+	 */
+	expectedData := []byte{
+		// 測定日時 2024/02/28 10:20:30
+		0x85, 0x07, 0x07, 0xe8, 0x02, 0x1c, 0x0a, 0x14, 0x1e,
+		// 終了
+		0x80,
+	}
+
+	got, err := AnonymizeWithOptions(testData, Options{DateShiftDays: -2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// テストの判定
+	if !bytes.Equal(expectedData, got) {
+		t.Fatalf("expected: %v, got %v", expectedData, got)
+	}
+}
+
+func TestAnonymizeDateShiftEvent(t *testing.T) {
+	// テストデータ
+	testData := []byte{
+		// 測定日時 2024/03/01 10:20:30
+		0x85, 0x07, 0x07, 0xe8, 0x03, 0x01, 0x0a, 0x14, 0x1e,
+		// 日時を持つイベント (グループ内の TIME がイベントの発生日時を表す)
+		0x67, 0x15,
+		// イベントコード 1, 開始位置 500, 持続 0
+		0x41, 0x0a, 0x00, 0x01, 0x00, 0x00, 0x01, 0xf4, 0x00, 0x00, 0x00, 0x00,
+		// 発生日時 2024/03/01 10:20:31
+		0x85, 0x07, 0x07, 0xe8, 0x03, 0x01, 0x0a, 0x14, 0x1f,
+		// 終了
+		0x80,
+	}
+
+	/*
+	 * 関数の返り値として期待されるデータ
+	 * - 測定日時とイベントの発生日時が同じだけ(2日前に)ずれる
+	 * - イベントの開始位置(サンプル位置)は変わらない
+	 */
+	expectedData := []byte{
+		0x85, 0x07, 0x07, 0xe8, 0x02, 0x1c, 0x0a, 0x14, 0x1e,
+		0x67, 0x15,
+		0x41, 0x0a, 0x00, 0x01, 0x00, 0x00, 0x01, 0xf4, 0x00, 0x00, 0x00, 0x00,
+		0x85, 0x07, 0x07, 0xe8, 0x02, 0x1c, 0x0a, 0x14, 0x1f,
+		0x80,
+	}
+
+	got, err := AnonymizeWithOptions(testData, Options{DateShiftDays: -2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// テストの判定
+	if !bytes.Equal(expectedData, got) {
+		t.Fatalf("expected: %v, got %v", expectedData, got)
+	}
+}
+
+func TestAnonymizeText(t *testing.T) {
+	// テストデータ
+	testData := []byte{
+		// コメント
+		0x16, 0x03, 0x41, 0x42, 0x43,
+		// 機器情報
+		0x17, 0x02, 0x58, 0x59,
+		// UID
+		0x87, 0x02, 0x31, 0x32,
+		// 終了
+		0x80,
+	}
+
+	/*
+	 * 関数の返り値として期待されるデータ
+	 * - コメントが空になる
+	 * - 機器情報はそのまま残る
+	 * - UIDが削除される
+	 */
+	expectedData := []byte{
+		// コメント
+		0x16, 0x00,
+		// 機器情報
+		0x17, 0x02, 0x58, 0x59,
+		// 終了
+		0x80,
+	}
+
+	actions, err := ParseTextActions("COMMENT=blank, machine_info=keep, UID=delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := AnonymizeWithOptions(testData, Options{TextActions: actions})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// テストの判定
+	if !bytes.Equal(expectedData, got) {
+		t.Fatalf("expected: %v, got %v", expectedData, got)
+	}
+}
+
+func TestAnonymizePseudonymousUID(t *testing.T) {
+	testData := []byte{0x87, 0x02, 0x31, 0x32, 0x80}
+
+	first, err := AnonymizeWithOptions(testData, Options{PseudonymKey: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := AnonymizeWithOptions(testData, Options{PseudonymKey: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 同じ鍵と UID からは同じ仮名の UID が得られる
+	if !bytes.Equal(first, second) {
+		t.Fatalf("pseudonymous UID is not deterministic: %v, %v", first, second)
+	}
+	if len(first) != 2+32+1 {
+		t.Fatalf("unexpected pseudonymous UID: %v", first)
+	}
+}
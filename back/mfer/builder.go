@@ -0,0 +1,120 @@
+package mfer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// LeadName は LDN タグの誘導コードに対応する誘導名を返す
+func LeadName(code int) string {
+	if name, ok := leadNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("LDN%d", code)
+}
+
+// LeadCode は誘導名に対応する LDN タグの誘導コードを返す
+func LeadCode(name string) (int, bool) {
+	for code, n := range leadNames {
+		if n == name {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+// Header は NewFile で書く患者と記録の情報
+type Header struct {
+	PatientID string
+	Sex       byte       // P_SEX (0: 不明, 1: 男性, 2: 女性)
+	Age       PatientAge // 年齢(年)と生年月のみ
+	Time      time.Time  // 記録開始日時 (ゼロ値の場合は書かない)
+}
+
+// NewFile は波形と患者情報からビッグエンディアンの MWF ファイルを作る
+// 生の値は16bit符号付き整数として書くため、Raw はその範囲の整数でなければならない
+func NewFile(header Header, w *Waveform) (*File, error) {
+	if len(w.Channels) == 0 {
+		return nil, fmt.Errorf("%w: no channels", ErrUnsupportedWaveform)
+	}
+	if w.SamplingRate <= 0 {
+		return nil, fmt.Errorf("%w: sampling rate %v", ErrUnsupportedWaveform, w.SamplingRate)
+	}
+	order := binary.BigEndian
+	nsamp := len(w.Channels[0].Raw)
+
+	f := &File{}
+	f.Tags = append(f.Tags,
+		NewTag(PREAMBLE, []byte(fmt.Sprintf("%-32s", "MFER Standard 12 leads ECG"))),
+		NewTag(BYTE_ORDER, []byte{0}),
+		NewTag(CHAR_CODE, []byte("UTF-8")),
+		NewTag(INTERVAL, encodeScaled(1, -6, uint32(math.Round(1e6/w.SamplingRate)), order)),
+		NewTag(DATA_TYPE, []byte{DataTypeInt16}),
+		NewTag(BLOCK, encodeCount(nsamp, order)),
+		NewTag(CHANNEL, encodeCount(len(w.Channels), order)),
+		NewTag(SEQUENCE, encodeCount(1, order)),
+	)
+
+	// 1シーケンスにチャネル順で全サンプルを並べる
+	data := make([]byte, 0, nsamp*len(w.Channels)*2)
+	for i, ch := range w.Channels {
+		if len(ch.Raw) != nsamp {
+			return nil, fmt.Errorf("%w: channel %s has %d samples, expected %d", ErrUnsupportedWaveform, ch.Lead, len(ch.Raw), nsamp)
+		}
+
+		attr := &Tag{Code: CHANNEL_ATTRIBUTE, Channel: i}
+		if code, ok := LeadCode(ch.Lead); ok {
+			attr.Children = append(attr.Children, NewTag(LDN, []byte{byte(code)}))
+		}
+		// 分解能は nV 単位の整数で書く
+		attr.Children = append(attr.Children, NewTag(SENSITIVITY, encodeScaled(ch.Unit, -9, uint32(math.Round(ch.Resolution*1e3)), order)))
+		if ch.Offset != 0 {
+			offset, err := int16Value(ch.Offset)
+			if err != nil {
+				return nil, err
+			}
+			attr.Children = append(attr.Children, NewTag(OFFSET, order.AppendUint16(nil, uint16(offset))))
+		}
+		f.Tags = append(f.Tags, attr)
+
+		for _, v := range ch.Raw {
+			raw, err := int16Value(v)
+			if err != nil {
+				return nil, fmt.Errorf("channel %s: %w", ch.Lead, err)
+			}
+			data = order.AppendUint16(data, uint16(raw))
+		}
+	}
+
+	// about patient
+	if header.PatientID != "" {
+		f.Tags = append(f.Tags, NewTag(P_ID, []byte(header.PatientID)))
+	}
+	if header.Age.BirthYear != 0 {
+		f.Tags = append(f.Tags, NewTag(P_AGE, header.Age.Encode(order)))
+	}
+	f.Tags = append(f.Tags, NewTag(P_SEX, []byte{header.Sex}))
+	if !header.Time.IsZero() {
+		f.Tags = append(f.Tags, NewTag(TIME, EncodeTime(header.Time, 11, order)))
+	}
+
+	f.Tags = append(f.Tags, NewTag(DATA, data), NewTag(END, nil))
+	return f, nil
+}
+
+func encodeScaled(unit byte, exponent int8, mantissa uint32, order binary.AppendByteOrder) []byte {
+	return order.AppendUint32([]byte{unit, byte(exponent)}, mantissa)
+}
+
+func encodeCount(n int, order binary.AppendByteOrder) []byte {
+	return order.AppendUint32(nil, uint32(n))
+}
+
+func int16Value(v float64) (int16, error) {
+	if v != math.Trunc(v) || v < math.MinInt16 || v > math.MaxInt16 {
+		return 0, fmt.Errorf("%w: value %v does not fit in 16-bit integer", ErrUnsupportedWaveform, v)
+	}
+	return int16(v), nil
+}
@@ -0,0 +1,220 @@
+package mfer
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/lzw"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// COMPRESSION タグの圧縮コード
+// COMPRESSION タグの内容は 圧縮コード 2バイト に続けて、圧縮前の波形データのバイト数 4バイト を省略可能で書く
+// DATA タグはタグごとに圧縮する
+const (
+	CompressionNone    = 0
+	CompressionDeflate = 1 // DEFLATE (RFC 1951)
+	CompressionLZW     = 2 // LZW (LSB 順, 8bit リテラル)
+)
+
+// maxDecompressedSize は展開後の波形データの上限
+const maxDecompressedSize = 256 << 20
+
+var (
+	ErrUnsupportedCompression = errors.New("unsupported compression")
+	ErrCorruptCompression     = errors.New("corrupt compressed data")
+)
+
+// Compression は COMPRESSION タグで宣言された圧縮コードを返す
+func (f *File) Compression() (int, error) {
+	method, _, err := f.compression()
+	return method, err
+}
+
+// compression は圧縮コードと宣言された圧縮前のバイト数(宣言がなければ -1)を返す
+// 扱えない圧縮コードの場合は COMPRESSION タグの位置を持つ *Error を返す
+func (f *File) compression() (int, int, error) {
+	tag := f.Find(COMPRESSION)
+	if tag == nil {
+		return CompressionNone, -1, nil
+	}
+
+	order := f.ByteOrder()
+	method, size := 0, -1
+	switch len(tag.Contents) {
+	case 2:
+		method = int(order.Uint16(tag.Contents))
+	case 6:
+		method = int(order.Uint16(tag.Contents))
+		size = int(order.Uint32(tag.Contents[2:]))
+	default:
+		return 0, 0, &Error{Offset: tag.Offset, Code: tag.Code, Err: ErrBadLength}
+	}
+	switch method {
+	case CompressionNone, CompressionDeflate, CompressionLZW:
+	default:
+		return method, size, &Error{
+			Offset: tag.Offset,
+			Code:   tag.Code,
+			Err:    fmt.Errorf("%w: code %d", ErrUnsupportedCompression, method),
+		}
+	}
+	return method, size, nil
+}
+
+// WaveformData はすべての DATA タグの内容を展開して連結したバイト列を返す
+// 扱えない圧縮コードが宣言されている場合はエラーを返す
+func (f *File) WaveformData() ([]byte, error) {
+	blocks, size, err := f.decompressData()
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	for _, b := range blocks {
+		data = append(data, b...)
+	}
+
+	if size >= 0 && size != len(data) {
+		return nil, fmt.Errorf("%w: waveform data is %d bytes but COMPRESSION declares %d", ErrBadLength, len(data), size)
+	}
+	return data, nil
+}
+
+// Decompress は DATA タグを展開し、COMPRESSION タグを削除する
+// 扱えない圧縮コードや壊れた圧縮データの場合はファイルを変更せずにエラーを返す
+func (f *File) Decompress() error {
+	blocks, _, err := f.decompressData()
+	if err != nil {
+		return err
+	}
+
+	i := 0
+	for _, tag := range f.Tags {
+		if tag.Code == DATA {
+			tag.Contents = blocks[i]
+			i++
+		}
+	}
+	f.Remove(COMPRESSION)
+	return nil
+}
+
+// Compress は DATA タグを method で圧縮し、COMPRESSION タグを書く
+// すでに圧縮されている場合は一度展開してから圧縮し直す
+func (f *File) Compress(method int) error {
+	switch method {
+	case CompressionNone, CompressionDeflate, CompressionLZW:
+	default:
+		return fmt.Errorf("%w: code %d", ErrUnsupportedCompression, method)
+	}
+	if err := f.Decompress(); err != nil {
+		return err
+	}
+	if method == CompressionNone {
+		return nil
+	}
+
+	size := 0
+	for _, tag := range f.Tags {
+		if tag.Code != DATA {
+			continue
+		}
+		size += len(tag.Contents)
+		b, err := compress(tag.Contents, method)
+		if err != nil {
+			return err
+		}
+		tag.Contents = b
+	}
+
+	order := f.ByteOrder()
+	contents := make([]byte, 6)
+	order.PutUint16(contents, uint16(method))
+	order.PutUint32(contents[2:], uint32(size))
+
+	// COMPRESSION タグは最初の DATA タグより前に置く
+	tag := NewTag(COMPRESSION, contents)
+	for i, t := range f.Tags {
+		if t.Code == DATA || t.Code == END {
+			f.Tags = append(f.Tags[:i], append([]*Tag{tag}, f.Tags[i:]...)...)
+			return nil
+		}
+	}
+	f.Tags = append(f.Tags, tag)
+	return nil
+}
+
+// decompressData は DATA タグごとに展開した内容と、宣言された圧縮前のバイト数を返す
+func (f *File) decompressData() ([][]byte, int, error) {
+	method, size, err := f.compression()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var blocks [][]byte
+	total := 0
+	for _, tag := range f.Tags {
+		if tag.Code != DATA {
+			continue
+		}
+		b, err := decompress(tag.Contents, method, maxDecompressedSize-total)
+		if err != nil {
+			return nil, 0, &Error{Offset: tag.Offset, Code: tag.Code, Err: err}
+		}
+		total += len(b)
+		blocks = append(blocks, b)
+	}
+	return blocks, size, nil
+}
+
+// decompress は data を展開する (展開後が limit バイトを超える場合はエラーにする)
+func decompress(data []byte, method, limit int) ([]byte, error) {
+	var r io.ReadCloser
+	switch method {
+	case CompressionNone:
+		return data, nil
+	case CompressionDeflate:
+		r = flate.NewReader(bytes.NewReader(data))
+	case CompressionLZW:
+		r = lzw.NewReader(bytes.NewReader(data), lzw.LSB, 8)
+	default:
+		return nil, fmt.Errorf("%w: code %d", ErrUnsupportedCompression, method)
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptCompression, err)
+	}
+	if len(b) > limit {
+		return nil, fmt.Errorf("%w: decompressed data exceeds %d bytes", ErrBadLength, maxDecompressedSize)
+	}
+	return b, nil
+}
+
+func compress(data []byte, method int) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch method {
+	case CompressionDeflate:
+		fw, err := flate.NewWriter(&buf, flate.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		w = fw
+	case CompressionLZW:
+		w = lzw.NewWriter(&buf, lzw.LSB, 8)
+	default:
+		return nil, fmt.Errorf("%w: code %d", ErrUnsupportedCompression, method)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
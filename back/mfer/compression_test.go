@@ -0,0 +1,164 @@
+package mfer
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCompressionNone(t *testing.T) {
+	// テストデータ
+	testData := []byte{
+		// 圧縮コード 0 (非圧縮), 圧縮前のバイト数 8
+		0x0e, 0x06, 0x00, 0x00, 0x00, 0x00, 0x00, 0x08,
+		// ブロック長, チャネル数
+		0x04, 0x01, 0x04,
+		0x05, 0x01, 0x01,
+		// 波形データ
+		0x1e, 0x08, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x04,
+		// 終了
+		0x80,
+	}
+
+	f, err := Parse(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := f.WaveformData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(testData[16:24], got) {
+		t.Errorf("expected: %v, got %v", testData[16:24], got)
+	}
+
+	// 展開すると COMPRESSION タグだけがなくなる
+	if err := f.Decompress(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(testData[8:], f.Encode()) {
+		t.Errorf("expected: %v, got %v", testData[8:], f.Encode())
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	// テストデータ
+	testData := []byte{
+		// ブロック長, チャネル数
+		0x04, 0x01, 0x02,
+		0x05, 0x01, 0x01,
+		// 波形データ (2つの DATA タグ)
+		0x1e, 0x04, 0x00, 0x01, 0x00, 0x02,
+		0x1e, 0x04, 0x00, 0x03, 0x00, 0x04,
+		// 終了
+		0x80,
+	}
+
+	for _, method := range []int{CompressionDeflate, CompressionLZW} {
+		f, err := Parse(testData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := f.Waveform()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := f.Compress(method); err != nil {
+			t.Fatal(err)
+		}
+
+		// 圧縮したファイルを読み直しても同じ波形が得られる
+		compressed, err := Parse(f.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := compressed.Compression(); err != nil || got != method {
+			t.Fatalf("unexpected compression, got: %d, %v, want: %d", got, err, method)
+		}
+		got, err := compressed.Waveform()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !want.Equal(got) {
+			t.Errorf("method %d: waveform changed by compression", method)
+		}
+
+		// 匿名化しても圧縮したまま波形が残る
+		anonymized, err := Anonymize(compressed.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		af, err := Parse(anonymized)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := af.Waveform(); err != nil || !want.Equal(got) {
+			t.Errorf("method %d: waveform changed by anonymization: %v", method, err)
+		}
+
+		// 展開すると元のファイルに戻る
+		if err := compressed.Decompress(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(testData, compressed.Encode()) {
+			t.Errorf("method %d: expected: %v, got %v", method, testData, compressed.Encode())
+		}
+	}
+}
+
+func TestCorruptCompression(t *testing.T) {
+	// DEFLATE を宣言しているが DATA タグは圧縮されていない
+	data := []byte{0x0e, 0x02, 0x00, 0x01, 0x1e, 0x04, 0xff, 0xff, 0xff, 0xff, 0x80}
+	f, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.WaveformData()
+	var mferErr *Error
+	if !errors.Is(err, ErrCorruptCompression) || !errors.As(err, &mferErr) || mferErr.Code != DATA {
+		t.Errorf("expected corrupt compression error, got %v", err)
+	}
+	if err := f.Decompress(); !errors.Is(err, ErrCorruptCompression) {
+		t.Errorf("expected corrupt compression error from Decompress, got %v", err)
+	}
+	if !bytes.Equal(data, f.Encode()) {
+		t.Error("file changed by failed Decompress")
+	}
+}
+
+func TestUnsupportedCompression(t *testing.T) {
+	for _, code := range []byte{0x03, 0x09} {
+		data := []byte{0x0e, 0x02, 0x00, code, 0x1e, 0x01, 0x00, 0x80}
+		f, err := Parse(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = f.WaveformData()
+		var mferErr *Error
+		if !errors.Is(err, ErrUnsupportedCompression) || !errors.As(err, &mferErr) || mferErr.Code != COMPRESSION {
+			t.Errorf("code %d: expected unsupported compression error, got %v", code, err)
+		}
+		if _, err := f.Waveform(); !errors.Is(err, ErrUnsupportedCompression) {
+			t.Errorf("code %d: expected unsupported compression error from Waveform, got %v", code, err)
+		}
+		if err := f.Decompress(); !errors.Is(err, ErrUnsupportedCompression) {
+			t.Errorf("code %d: expected unsupported compression error from Decompress, got %v", code, err)
+		}
+		if !bytes.Equal(data, f.Encode()) {
+			t.Errorf("code %d: file changed by failed Decompress", code)
+		}
+	}
+}
+
+func TestCompressUnsupportedMethod(t *testing.T) {
+	f, err := Parse([]byte{0x1e, 0x02, 0x00, 0x01, 0x80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Compress(9); !errors.Is(err, ErrUnsupportedCompression) {
+		t.Errorf("expected unsupported compression error, got %v", err)
+	}
+}
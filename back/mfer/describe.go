@@ -0,0 +1,117 @@
+package mfer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// identifyingTags は患者や記録、機器を識別しうる内容を持つタグ
+var identifyingTags = map[byte]bool{
+	P_NAME:       true,
+	P_ID:         true,
+	P_AGE:        true,
+	TIME:         true,
+	COMMENT:      true,
+	MESSAGE:      true,
+	MACHINE_INFO: true,
+	UID:          true,
+}
+
+// IsIdentifying は患者や記録、機器を識別しうる内容を持つタグかどうかを返す
+// 内容が分からない機器独自のタグも識別しうるものとして扱う
+func IsIdentifying(code byte) bool {
+	return identifyingTags[code] || CategoryOf(code) == CategoryUnknown
+}
+
+// maxDumpBytes は解釈できないタグの内容を 16 進数で表示するバイト数の上限
+const maxDumpBytes = 16
+
+// Describe はタグの内容を人が読める形にする
+// 子タグを持つタグと DATA は空文字列を返し、解釈できない内容は先頭を 16 進数で返す
+func (f *File) Describe(tag *Tag) string {
+	order := f.ByteOrder()
+	contents := tag.Contents
+	switch tag.Code {
+	case CHANNEL_ATTRIBUTE, GROUP, DATA, END:
+		return ""
+	case BYTE_ORDER:
+		if len(contents) == 1 && contents[0] == 1 {
+			return "little endian"
+		}
+		return "big endian"
+	case VERSION:
+		if len(contents) == 3 {
+			return fmt.Sprintf("%d.%d.%d", contents[0], contents[1], contents[2])
+		}
+	case CHAR_CODE, PREAMBLE:
+		return fmt.Sprintf("%q", strings.TrimRight(string(contents), " \x00"))
+	case P_NAME, P_ID, COMMENT, MESSAGE, MACHINE_INFO, UID:
+		if s, err := f.DecodeString(contents); err == nil {
+			return fmt.Sprintf("%q", s)
+		}
+	case P_AGE:
+		if age, err := DecodeAge(contents, order); err == nil {
+			s := fmt.Sprintf("%d years", age.Years)
+			if age.BirthYear != 0 {
+				s += fmt.Sprintf(", born %04d-%02d-%02d", age.BirthYear, age.BirthMonth, age.BirthDay)
+			}
+			return s
+		}
+	case P_SEX:
+		if len(contents) == 1 {
+			switch contents[0] {
+			case 0:
+				return "unknown"
+			case 1:
+				return "male"
+			case 2:
+				return "female"
+			}
+			return fmt.Sprintf("other (%d)", contents[0])
+		}
+	case TIME:
+		if t, err := DecodeTime(contents, order); err == nil {
+			return t.Format("2006-01-02 15:04:05.000000")
+		}
+	case INTERVAL:
+		if rate, err := decodeRate(contents, order); err == nil {
+			return fmt.Sprintf("%g Hz", rate)
+		}
+	case SENSITIVITY:
+		if unit, value, err := decodeScaled(contents, order); err == nil {
+			if unit == sensitivityUnitVolt {
+				return fmt.Sprintf("%g µV", value*1e6)
+			}
+			return fmt.Sprintf("%g (unit %d)", value, unit)
+		}
+	case DATA_TYPE:
+		if len(contents) == 1 {
+			return fmt.Sprintf("%d", contents[0])
+		}
+	case WAVE_FORM_TYPE, COMPRESSION:
+		if len(contents) >= 2 {
+			return fmt.Sprintf("%d", order.Uint16(contents[:2]))
+		}
+	case BLOCK, CHANNEL, SEQUENCE:
+		if n, err := decodeCount(contents, order); err == nil {
+			return fmt.Sprintf("%d", n)
+		}
+	case LDN:
+		if len(contents) == 1 {
+			return LeadName(int(contents[0]))
+		}
+		if len(contents) >= 2 {
+			return LeadName(int(order.Uint16(contents[:2])))
+		}
+	}
+
+	dump := contents
+	if len(dump) > maxDumpBytes {
+		dump = dump[:maxDumpBytes]
+	}
+	s := fmt.Sprintf("% x", dump)
+	if len(contents) > maxDumpBytes {
+		s += " ..."
+	}
+	return s
+}
@@ -0,0 +1,54 @@
+package mfer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDescribe(t *testing.T) {
+	w := &Waveform{
+		SamplingRate: 500,
+		Channels:     []Channel{{Lead: "aVR", Resolution: 2.5, Raw: []float64{1, 2}}},
+	}
+	header := Header{
+		PatientID: "12345",
+		Sex:       2,
+		Age:       PatientAge{Years: 40, BirthYear: 1984, BirthMonth: 11},
+		Time:      time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+	}
+	f, err := NewFile(header, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[byte]string{
+		BYTE_ORDER:  "big endian",
+		CHAR_CODE:   `"UTF-8"`,
+		INTERVAL:    "500 Hz",
+		BLOCK:       "2",
+		LDN:         "aVR",
+		SENSITIVITY: "2.5 µV",
+		P_ID:        `"12345"`,
+		P_AGE:       "40 years, born 1984-11-00",
+		P_SEX:       "female",
+		TIME:        "2024-05-06 07:08:09.000000",
+		DATA:        "",
+	}
+	f.Walk(func(tag *Tag) bool {
+		want, ok := expected[tag.Code]
+		if !ok {
+			return true
+		}
+		if got := f.Describe(tag); got != want {
+			t.Errorf("unexpected description of %s, got: %q, want: %q", TagName(tag.Code), got, want)
+		}
+		return true
+	})
+
+	if got := f.Describe(NewTag(EVENT, []byte{0xde, 0xad})); got != "de ad" {
+		t.Errorf("unexpected dump, got: %q", got)
+	}
+	if name := TagName(0x50); name != "0x50" {
+		t.Errorf("unexpected name of unknown tag: %s", name)
+	}
+}
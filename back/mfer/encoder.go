@@ -0,0 +1,145 @@
+package mfer
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// NewTag は新しいタグを作成する
+func NewTag(code byte, contents []byte) *Tag {
+	return &Tag{Code: code, Contents: contents}
+}
+
+// Encode はタグの木を MWF ファイルのバイト列に変換する
+// パース後に変更していないファイルは元のバイト列と同一になる
+func (f *File) Encode() []byte {
+	e := &encoder{order: binary.BigEndian, top: true}
+	e.encodeTags(f.Tags)
+	e.buf.Write(f.Trailer)
+	return e.buf.Bytes()
+}
+
+// Encode はタグ1つ分のバイト列を返す
+// 長形式の長さは order に従って書く
+func (t *Tag) Encode(order binary.ByteOrder) []byte {
+	e := &encoder{order: order}
+	e.encodeTag(t)
+	return e.buf.Bytes()
+}
+
+type encoder struct {
+	buf   bytes.Buffer
+	order binary.ByteOrder // 長形式の長さを書くバイトオーダー (トップレベルの BYTE_ORDER タグ以降はその宣言に従う)
+	top   bool             // トップレベルのタグ列を書いているかどうか
+}
+
+func (e *encoder) encodeTags(tags []*Tag) {
+	for _, tag := range tags {
+		e.encodeTag(tag)
+	}
+}
+
+func (e *encoder) encodeTag(tag *Tag) {
+	buf := &e.buf
+	buf.WriteByte(tag.Code)
+	if tag.Code == ZERO || tag.Code == END {
+		return
+	}
+
+	if tag.Code == CHANNEL_ATTRIBUTE {
+		writeChannel(buf, tag.Channel, tag.channelSize)
+	}
+
+	contents := tag.Contents
+	if tag.IsContainer() {
+		children := &encoder{order: e.order}
+		children.encodeTags(tag.Children)
+		contents = children.buf.Bytes()
+	}
+
+	writeLength(buf, uint32(len(contents)), tag.lengthSize, e.order)
+	buf.Write(contents)
+
+	if e.top && tag.Code == BYTE_ORDER {
+		e.order = byteOrderOf(tag.Contents)
+	}
+}
+
+// writeLength は長さを書き込む
+// 0x7f 以下は1バイトの短形式、それ以上は 0x80|バイト数 に続けて書く長形式を使う
+// size には元のファイルが長形式で使っていたバイト数を渡し、収まる限りそれに合わせる
+func writeLength(buf *bytes.Buffer, length uint32, size int, order binary.ByteOrder) {
+	if size == 0 && length <= 0x7f {
+		buf.WriteByte(byte(length))
+		return
+	}
+
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, length)
+
+	numBytes := 4
+	for numBytes > 1 && b[4-numBytes] == 0 {
+		numBytes--
+	}
+	if size > numBytes && size <= 4 {
+		numBytes = size
+	}
+
+	buf.WriteByte(0x80 | byte(numBytes))
+	if order == binary.LittleEndian {
+		for i := 3; i >= 4-numBytes; i-- {
+			buf.WriteByte(b[i])
+		}
+		return
+	}
+	buf.Write(b[4-numBytes:])
+}
+
+func writeChannel(buf *bytes.Buffer, channel, size int) {
+	if channel <= 0x7f && size != 2 {
+		buf.WriteByte(byte(channel))
+		return
+	}
+	buf.WriteByte(0x80 | byte(channel>>8))
+	buf.WriteByte(byte(channel))
+}
+
+// Set は code のトップレベルのタグの内容を置き換える
+// タグが存在しない場合は END タグの直前に追加する
+func (f *File) Set(code byte, contents []byte) {
+	if tag := f.Find(code); tag != nil {
+		tag.Contents = contents
+		return
+	}
+	f.Insert(NewTag(code, contents))
+}
+
+// Insert はタグを END タグの直前に追加する
+func (f *File) Insert(tag *Tag) {
+	for i, t := range f.Tags {
+		if t.Code == END {
+			f.Tags = append(f.Tags[:i], append([]*Tag{tag}, f.Tags[i:]...)...)
+			return
+		}
+	}
+	f.Tags = append(f.Tags, tag)
+}
+
+// Remove は code に一致するタグを子タグも含めてすべて削除する
+func (f *File) Remove(code byte) {
+	f.Tags = removeTags(f.Tags, code)
+}
+
+func removeTags(tags []*Tag, code byte) []*Tag {
+	kept := tags[:0]
+	for _, tag := range tags {
+		if tag.Code == code {
+			continue
+		}
+		if tag.IsContainer() {
+			tag.Children = removeTags(tag.Children, code)
+		}
+		kept = append(kept, tag)
+	}
+	return kept
+}
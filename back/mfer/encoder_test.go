@@ -0,0 +1,51 @@
+package mfer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	// テストデータ
+	testData := []byte{
+		// プリアンブル
+		0x40, 0x04, 0x4d, 0x46, 0x52, 0x20,
+		// バイトオーダー
+		0x01, 0x01, 0x00,
+		// チャネル1の属性 (誘導 I)
+		0x3f, 0x00, 0x03, 0x09, 0x01, 0x01,
+		// 患者ID (必要以上に長い長形式)
+		0x82, 0x82, 0x00, 0x03, 0x31, 0x32, 0x33,
+		// パディング
+		0x00,
+		// 終了
+		0x80,
+	}
+
+	f, err := Parse(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := f.Encode(); !bytes.Equal(testData, got) {
+		t.Fatalf("expected: %v, got %v", testData, got)
+	}
+}
+
+func TestEncodeModified(t *testing.T) {
+	f, err := Parse([]byte{0x82, 0x03, 0x31, 0x32, 0x33, 0x80})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 0x7f を超える長さは長形式で書かれる
+	f.Set(P_ID, bytes.Repeat([]byte{0x41}, 0x100))
+	f.Set(P_SEX, []byte{0x01})
+
+	expectedData := append([]byte{0x82, 0x82, 0x01, 0x00}, bytes.Repeat([]byte{0x41}, 0x100)...)
+	expectedData = append(expectedData, 0x84, 0x01, 0x01, 0x80)
+
+	if got := f.Encode(); !bytes.Equal(expectedData, got) {
+		t.Fatalf("expected: %v, got %v", expectedData, got)
+	}
+}
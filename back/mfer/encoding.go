@@ -0,0 +1,74 @@
+package mfer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// ByteOrder は BYTE_ORDER タグで宣言されたバイトオーダーを返す
+// 0 がビッグエンディアン、1 がリトルエンディアンで、タグがない場合はビッグエンディアンとする
+func (f *File) ByteOrder() binary.ByteOrder {
+	if tag := f.Find(BYTE_ORDER); tag != nil {
+		return byteOrderOf(tag.Contents)
+	}
+	return binary.BigEndian
+}
+
+func byteOrderOf(contents []byte) binary.ByteOrder {
+	if len(contents) > 0 && contents[0] == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// CharCode は CHAR_CODE タグで宣言された文字コード名を返す
+func (f *File) CharCode() string {
+	if tag := f.Find(CHAR_CODE); tag != nil {
+		return string(bytes.TrimRight(tag.Contents, "\x00 "))
+	}
+	return ""
+}
+
+// textEncoding は文字コード名に対応するエンコーディングを返す
+// 宣言がない場合と ASCII の場合は UTF-8 として扱う
+func textEncoding(charCode string) (encoding.Encoding, error) {
+	switch strings.ToUpper(strings.TrimSpace(charCode)) {
+	case "", "ASCII", "UNICODE", "UTF-8", "UTF8":
+		return unicode.UTF8, nil
+	case "SJIS", "SHIFT_JIS", "SHIFT-JIS", "MS932", "CP932":
+		return japanese.ShiftJIS, nil
+	}
+	return nil, fmt.Errorf("unsupported character code %q", charCode)
+}
+
+// DecodeString は文字列タグの内容を宣言された文字コードで読み、末尾の NUL を取り除く
+func (f *File) DecodeString(contents []byte) (string, error) {
+	enc, err := textEncoding(f.CharCode())
+	if err != nil {
+		return "", err
+	}
+	b, err := enc.NewDecoder().Bytes(bytes.TrimRight(contents, "\x00"))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode string: %w", err)
+	}
+	return string(b), nil
+}
+
+// EncodeString は文字列を宣言された文字コードで書く
+func (f *File) EncodeString(s string) ([]byte, error) {
+	enc, err := textEncoding(f.CharCode())
+	if err != nil {
+		return nil, err
+	}
+	b, err := enc.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode string: %w", err)
+	}
+	return b, nil
+}
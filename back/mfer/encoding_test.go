@@ -0,0 +1,76 @@
+package mfer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseLittleEndian(t *testing.T) {
+	// テストデータ
+	testData := append([]byte{
+		// バイトオーダー (リトルエンディアン)
+		0x01, 0x01, 0x01,
+		// コメント (長さ 0x0100 をリトルエンディアンの長形式で書く)
+		0x16, 0x82, 0x00, 0x01,
+	}, append(bytes.Repeat([]byte{0x41}, 0x100), 0x80)...)
+
+	f, err := Parse(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.ByteOrder() != binary.LittleEndian {
+		t.Fatalf("unexpected byte order: %v", f.ByteOrder())
+	}
+	if comment := f.Find(COMMENT); comment == nil || len(comment.Contents) != 0x100 {
+		t.Fatalf("unexpected comment tag: %+v", comment)
+	}
+
+	// リトルエンディアンのまま書き戻される
+	if got := f.Encode(); !bytes.Equal(testData, got) {
+		t.Fatalf("expected: %v, got %v", testData, got)
+	}
+}
+
+func TestDecodeString(t *testing.T) {
+	// テストデータ
+	testData := []byte{
+		// 文字コード
+		0x03, 0x04, 0x53, 0x4a, 0x49, 0x53,
+		// 患者名 (Shift_JIS の「山田」)
+		0x81, 0x05, 0x8e, 0x52, 0x93, 0x63, 0x00,
+		// 終了
+		0x80,
+	}
+
+	f, err := Parse(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := f.DecodeString(f.Find(P_NAME).Contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "山田" {
+		t.Errorf("unexpected name, got: %s, want: %s", name, "山田")
+	}
+
+	encoded, err := f.EncodeString("山田")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, []byte{0x8e, 0x52, 0x93, 0x63}) {
+		t.Errorf("unexpected encoded name: %v", encoded)
+	}
+}
+
+func TestDecodeAgeLittleEndian(t *testing.T) {
+	age, err := DecodeAge([]byte{0x16, 0xfe, 0x1f, 0xc0, 0x07, 0x0b, 0x17}, binary.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if age.Years != 22 || age.Days != 8190 || age.BirthYear != 1984 || age.BirthMonth != 11 || age.BirthDay != 23 {
+		t.Errorf("unexpected age: %+v", age)
+	}
+}
@@ -0,0 +1,27 @@
+package mfer
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrTruncated = errors.New("truncated tag")
+	ErrBadLength = errors.New("bad length")
+)
+
+// Error は MWF ファイルを読めなかったタグとその位置を表す
+// Err には ErrTruncated, ErrBadLength のいずれかが入る
+type Error struct {
+	Offset int
+	Code   byte
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("mfer: tag 0x%02x at offset %d: %v", e.Code, e.Offset, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
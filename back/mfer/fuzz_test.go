@@ -0,0 +1,30 @@
+package mfer
+
+import (
+	"bytes"
+	"testing"
+)
+
+// FuzzParse は壊れた MWF ファイルでパース、波形の読み込み、匿名化が panic しないことを確かめる
+// シードは testdata/fuzz/FuzzParse にある
+func FuzzParse(f *testing.F) {
+	f.Add([]byte{0x01, 0x01, 0x01, 0x82, 0x03, 0x31, 0x32, 0x33, 0x80})
+	f.Add([]byte{0x3f, 0x81, 0x00, 0x03, 0x09, 0x01, 0x01, 0x80})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		file, err := Parse(data)
+		if err != nil {
+			return
+		}
+
+		// 変更していないファイルは元のバイト列に戻る
+		if got := file.Encode(); !bytes.Equal(data, got) {
+			t.Fatalf("round trip mismatch: expected %v, got %v", data, got)
+		}
+
+		file.Waveform()
+
+		// 文字コードが未対応の場合などはエラーになるが panic してはいけない
+		AnonymizeWithOptions(data, Options{PseudonymID: "abcd", DateShiftDays: 10, PseudonymKey: []byte("key")})
+	})
+}
@@ -0,0 +1,231 @@
+package mfer
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Category はタグの分類を表す
+type Category int
+
+const (
+	CategoryUnknown Category = iota
+	CategoryControl
+	CategorySampling
+	CategoryFrame
+	CategoryWaveform
+	CategoryHelper
+	CategoryExtension
+)
+
+// File はパースされた MWF ファイルを表す
+type File struct {
+	Tags    []*Tag
+	Trailer []byte // END タグ以降のバイト列
+}
+
+// Tag は MWF ファイル中の1つのタグを表す
+type Tag struct {
+	Code     byte
+	Channel  int    // CHANNEL_ATTRIBUTE のチャネル番号
+	Offset   int    // ファイル先頭からのタグコードの位置
+	Contents []byte // 子タグを持たないタグの内容
+	Children []*Tag // CHANNEL_ATTRIBUTE と GROUP に含まれるタグ
+
+	lengthSize  int // 長さを長形式で書いていた場合のバイト数(短形式なら0)
+	channelSize int // チャネル番号のバイト数
+}
+
+// CategoryOf はタグコードの分類を返す
+func CategoryOf(code byte) Category {
+	switch code {
+	case BYTE_ORDER, VERSION, CHAR_CODE, ZERO, COMMENT, MACHINE_INFO, COMPRESSION:
+		return CategoryControl
+	case INTERVAL, SENSITIVITY, DATA_TYPE, OFFSET, NULL:
+		return CategorySampling
+	case BLOCK, CHANNEL, SEQUENCE, F_POINTER:
+		return CategoryFrame
+	case WAVE_FORM_TYPE, CHANNEL_ATTRIBUTE, LDN, INFORMATION, FILTER, IPD, DATA:
+		return CategoryWaveform
+	case PREAMBLE, EVENT, VALUE, CONDITION, ERROR, GROUP, R_POINTER, SIGNITURE:
+		return CategoryExtension
+	case P_NAME, P_ID, P_AGE, P_SEX, TIME, MESSAGE, UID, MAP, END:
+		return CategoryHelper
+	}
+	return CategoryUnknown
+}
+
+// Category はタグの分類を返す
+func (t *Tag) Category() Category {
+	return CategoryOf(t.Code)
+}
+
+// IsContainer は子タグを持つタグかどうかを返す
+func (t *Tag) IsContainer() bool {
+	return isContainer(t.Code)
+}
+
+func isContainer(code byte) bool {
+	return code == CHANNEL_ATTRIBUTE || code == GROUP
+}
+
+// Find は code に一致する最初のトップレベルのタグを返す
+func (f *File) Find(code byte) *Tag {
+	for _, tag := range f.Tags {
+		if tag.Code == code {
+			return tag
+		}
+	}
+	return nil
+}
+
+// Walk はすべてのタグを深さ優先で訪問する
+// fn が false を返した場合はそのタグの子タグを訪問しない
+func (f *File) Walk(fn func(tag *Tag) bool) {
+	walk(f.Tags, fn)
+}
+
+func walk(tags []*Tag, fn func(tag *Tag) bool) {
+	for _, tag := range tags {
+		if fn(tag) {
+			walk(tag.Children, fn)
+		}
+	}
+}
+
+// Parse は MWF ファイルのバイト列をタグの木に変換する
+func Parse(data []byte) (*File, error) {
+	p := &parser{data: data, order: binary.BigEndian}
+	tags, end, err := p.parseTags(0, len(data), true)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{Tags: tags}
+	if end < len(data) {
+		f.Trailer = data[end:]
+	}
+	return f, nil
+}
+
+type parser struct {
+	data  []byte
+	order binary.ByteOrder // 長形式の長さを読むバイトオーダー (トップレベルの BYTE_ORDER タグ以降はその宣言に従う)
+}
+
+// parseTags は data[start:limit] をタグ列として読む
+// top が true の場合は END タグで読み込みを終了し、その直後の位置を返す
+func (p *parser) parseTags(start, limit int, top bool) ([]*Tag, int, error) {
+	var tags []*Tag
+	i := start
+	for i < limit {
+		offset := i
+		code := p.data[i]
+		i++
+
+		if code == ZERO {
+			tags = append(tags, &Tag{Code: ZERO, Offset: offset})
+			continue
+		}
+		if code == END {
+			tags = append(tags, &Tag{Code: END, Offset: offset})
+			if top {
+				return tags, i, nil
+			}
+			continue
+		}
+
+		// 定義されていないタグ (機器独自のタグなど) も長さに従って読み、内容はそのまま残す
+		tag := &Tag{Code: code, Offset: offset}
+
+		if code == CHANNEL_ATTRIBUTE {
+			channel, n, err := p.readChannel(i, limit)
+			if err != nil {
+				return nil, 0, &Error{Offset: offset, Code: code, Err: err}
+			}
+			tag.Channel = channel
+			tag.channelSize = n
+			i += n
+		}
+
+		length, n, err := p.readLength(i, limit)
+		if err != nil {
+			return nil, 0, &Error{Offset: offset, Code: code, Err: err}
+		}
+		if n > 1 {
+			tag.lengthSize = n - 1
+		}
+		i += n
+
+		if uint64(length) > uint64(limit-i) {
+			return nil, 0, &Error{
+				Offset: offset,
+				Code:   code,
+				Err:    fmt.Errorf("%w: length %d exceeds remaining %d bytes", ErrTruncated, length, limit-i),
+			}
+		}
+		end := i + int(length)
+
+		if isContainer(code) {
+			children, _, err := p.parseTags(i, end, false)
+			if err != nil {
+				return nil, 0, err
+			}
+			tag.Children = children
+		} else {
+			tag.Contents = p.data[i:end]
+			if top && code == BYTE_ORDER {
+				p.order = byteOrderOf(tag.Contents)
+			}
+		}
+		tags = append(tags, tag)
+		i = end
+	}
+	return tags, i, nil
+}
+
+// readLength は位置 i から長さを読み、長さと消費したバイト数を返す
+func (p *parser) readLength(i, limit int) (uint32, int, error) {
+	if i >= limit {
+		return 0, 0, fmt.Errorf("%w: missing length", ErrTruncated)
+	}
+	length := uint32(p.data[i])
+	if length <= 0x7f {
+		return length, 1, nil
+	}
+
+	numBytes := int(length - 0x80) /* MSBが1ならば後続のバイト数 */
+	if numBytes == 0 || numBytes > 4 {
+		return 0, 0, fmt.Errorf("%w: invalid length byte 0x%02x", ErrBadLength, p.data[i])
+	}
+	if i+1+numBytes > limit {
+		return 0, 0, fmt.Errorf("%w: missing long-form length bytes", ErrTruncated)
+	}
+	return readUint(p.data[i+1:i+1+numBytes], p.order), 1 + numBytes, nil
+}
+
+// readChannel は CHANNEL_ATTRIBUTE のチャネル番号を読む
+// MSB が1の場合は2バイトで表される
+func (p *parser) readChannel(i, limit int) (int, int, error) {
+	if i >= limit {
+		return 0, 0, fmt.Errorf("%w: missing channel number", ErrTruncated)
+	}
+	b := p.data[i]
+	if b <= 0x7f {
+		return int(b), 1, nil
+	}
+	if i+1 >= limit {
+		return 0, 0, fmt.Errorf("%w: missing channel number byte", ErrTruncated)
+	}
+	return int(b&0x7f)<<8 | int(p.data[i+1]), 2, nil
+}
+
+// readUint は1〜4バイトの符号なし整数を order に従って読む
+func readUint(b []byte, order binary.ByteOrder) uint32 {
+	buf := make([]byte, 4)
+	if order == binary.LittleEndian {
+		copy(buf, b)
+	} else {
+		copy(buf[4-len(b):], b)
+	}
+	return order.Uint32(buf)
+}
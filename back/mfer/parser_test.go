@@ -0,0 +1,150 @@
+package mfer
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	// テストデータ
+	testData := []byte{
+		// バイトオーダー
+		0x01, 0x01, 0x00,
+		// サンプリング間隔 (1ms)
+		0x0b, 0x03, 0x01, 0xfd, 0x01,
+		// チャネル1の属性 (誘導 I)
+		0x3f, 0x00, 0x03, 0x09, 0x01, 0x01,
+		// 患者ID (長形式の長さ)
+		0x82, 0x81, 0x03, 0x31, 0x32, 0x33,
+		// パディング
+		0x00,
+		// 終了
+		0x80,
+		// 終了以降のデータ
+		0xff,
+	}
+
+	f, err := Parse(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Tags) != 6 {
+		t.Fatalf("unexpected number of tags, got: %d, want: %d", len(f.Tags), 6)
+	}
+
+	interval := f.Find(INTERVAL)
+	if interval == nil || interval.Offset != 3 || !bytes.Equal(interval.Contents, []byte{0x01, 0xfd, 0x01}) {
+		t.Errorf("unexpected interval tag: %+v", interval)
+	}
+	if interval.Category() != CategorySampling {
+		t.Errorf("unexpected category, got: %d, want: %d", interval.Category(), CategorySampling)
+	}
+
+	attr := f.Find(CHANNEL_ATTRIBUTE)
+	if attr == nil || attr.Channel != 0 || len(attr.Children) != 1 {
+		t.Fatalf("unexpected channel attribute: %+v", attr)
+	}
+	if ldn := attr.Children[0]; ldn.Code != LDN || ldn.Offset != 11 || !bytes.Equal(ldn.Contents, []byte{0x01}) {
+		t.Errorf("unexpected nested tag: %+v", ldn)
+	}
+
+	id := f.Find(P_ID)
+	if id == nil || string(id.Contents) != "123" {
+		t.Errorf("unexpected patient ID tag: %+v", id)
+	}
+
+	if !bytes.Equal(f.Trailer, []byte{0xff}) {
+		t.Errorf("unexpected trailer, got: %v", f.Trailer)
+	}
+}
+
+func TestParseVendorTag(t *testing.T) {
+	// テストデータ
+	testData := []byte{
+		// バイトオーダー
+		0x01, 0x01, 0x00,
+		// 機器独自のタグ
+		0x7e, 0x05, 0x59, 0x41, 0x4d, 0x41, 0x44,
+		// チャネル1の属性 (誘導 I と機器独自のタグ)
+		0x3f, 0x00, 0x06, 0x09, 0x01, 0x01, 0x7d, 0x01, 0x02,
+		// 波形データ
+		0x1e, 0x02, 0x00, 0x01,
+		// 終了
+		0x80,
+	}
+
+	f, err := Parse(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vendor := f.Find(0x7e)
+	if vendor == nil || vendor.Offset != 3 || string(vendor.Contents) != "YAMAD" || vendor.Category() != CategoryUnknown {
+		t.Errorf("unexpected vendor tag: %+v", vendor)
+	}
+	attr := f.Find(CHANNEL_ATTRIBUTE)
+	if attr == nil || len(attr.Children) != 2 || attr.Children[1].Code != 0x7d {
+		t.Fatalf("unexpected channel attribute: %+v", attr)
+	}
+
+	// 機器独自のタグはそのまま書き戻す
+	if !bytes.Equal(testData, f.Encode()) {
+		t.Errorf("expected: %v, got %v", testData, f.Encode())
+	}
+
+	// 匿名化では内容が分からないため削除する
+	anonymized, err := Anonymize(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	af, err := Parse(anonymized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	af.Walk(func(tag *Tag) bool {
+		if tag.Category() == CategoryUnknown {
+			t.Errorf("vendor tag remains: %+v", tag)
+		}
+		return true
+	})
+	if attr := af.Find(CHANNEL_ATTRIBUTE); attr == nil || len(attr.Children) != 1 || attr.Children[0].Code != LDN {
+		t.Errorf("unexpected channel attribute after anonymization: %+v", attr)
+	}
+	if bytes.Contains(anonymized, []byte("YAMAD")) {
+		t.Error("contents of vendor tag remain")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		err    error
+		offset int
+	}{
+		// 長さが残りのバイト数を超えている
+		{"truncated contents", []byte{0x01, 0x01, 0x00, 0x82, 0x05, 0x31}, ErrTruncated, 3},
+		// 長さのバイトがない
+		{"missing length", []byte{0x82}, ErrTruncated, 0},
+		// 長形式のバイト数が4を超えている
+		{"bad length", []byte{0x82, 0x85, 0x00, 0x00, 0x00, 0x00, 0x01}, ErrBadLength, 0},
+		// 定義されていないタグの長さが残りのバイト数を超えている
+		{"truncated unknown tag", []byte{0x01, 0x01, 0x00, 0x7e, 0x05, 0x00}, ErrTruncated, 3},
+		// チャネル属性の中のタグが途中で切れている
+		{"truncated nested tag", []byte{0x3f, 0x00, 0x02, 0x09, 0x02}, ErrTruncated, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.data)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("unexpected error, got: %v, want: %v", err, tt.err)
+			}
+			var mferErr *Error
+			if !errors.As(err, &mferErr) || mferErr.Offset != tt.offset {
+				t.Errorf("unexpected error offset, got: %v, want: %d", err, tt.offset)
+			}
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package mfer
 
+import "fmt"
+
 const (
 	// for Mfer.Sampling
 	INTERVAL    = 0x0b
@@ -53,3 +55,55 @@ const (
 	MAP     = 0x88
 	END     = 0x80
 )
+
+// tagNames はタグコードと定数名の対応
+var tagNames = map[byte]string{
+	INTERVAL:          "INTERVAL",
+	SENSITIVITY:       "SENSITIVITY",
+	DATA_TYPE:         "DATA_TYPE",
+	OFFSET:            "OFFSET",
+	NULL:              "NULL",
+	BLOCK:             "BLOCK",
+	CHANNEL:           "CHANNEL",
+	SEQUENCE:          "SEQUENCE",
+	F_POINTER:         "F_POINTER",
+	WAVE_FORM_TYPE:    "WAVE_FORM_TYPE",
+	CHANNEL_ATTRIBUTE: "CHANNEL_ATTRIBUTE",
+	LDN:               "LDN",
+	INFORMATION:       "INFORMATION",
+	FILTER:            "FILTER",
+	IPD:               "IPD",
+	DATA:              "DATA",
+	BYTE_ORDER:        "BYTE_ORDER",
+	VERSION:           "VERSION",
+	CHAR_CODE:         "CHAR_CODE",
+	ZERO:              "ZERO",
+	COMMENT:           "COMMENT",
+	MACHINE_INFO:      "MACHINE_INFO",
+	COMPRESSION:       "COMPRESSION",
+	PREAMBLE:          "PREAMBLE",
+	EVENT:             "EVENT",
+	VALUE:             "VALUE",
+	CONDITION:         "CONDITION",
+	ERROR:             "ERROR",
+	GROUP:             "GROUP",
+	R_POINTER:         "R_POINTER",
+	SIGNITURE:         "SIGNITURE",
+	P_NAME:            "P_NAME",
+	P_ID:              "P_ID",
+	P_AGE:             "P_AGE",
+	P_SEX:             "P_SEX",
+	TIME:              "TIME",
+	MESSAGE:           "MESSAGE",
+	UID:               "UID",
+	MAP:               "MAP",
+	END:               "END",
+}
+
+// TagName はタグコードの定数名を返す (未知のコードは 16 進数で返す)
+func TagName(code byte) string {
+	if name, ok := tagNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", code)
+}
@@ -0,0 +1,107 @@
+package mfer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// TextAction は自由記述タグ(COMMENT, MESSAGE, MACHINE_INFO, UID)の扱い方を表す
+type TextAction int
+
+const (
+	TextKeep         TextAction = iota // そのまま残す
+	TextBlank                          // 内容を空にする
+	TextDelete                         // タグごと削除する
+	TextPseudonymize                   // 元の値から決まる仮名の値に置き換える
+)
+
+// DefaultTextActions は Options.TextActions を指定しなかった場合の扱い方
+// 技師がコメントやメッセージに氏名や病棟を入力することがあり、機器情報と UID には機器のシリアル番号が含まれうる
+var DefaultTextActions = map[byte]TextAction{
+	COMMENT:      TextDelete,
+	MESSAGE:      TextDelete,
+	MACHINE_INFO: TextDelete,
+	UID:          TextPseudonymize,
+}
+
+var textTagNames = map[string]byte{
+	"COMMENT":      COMMENT,
+	"MESSAGE":      MESSAGE,
+	"MACHINE_INFO": MACHINE_INFO,
+	"UID":          UID,
+}
+
+var textActionNames = map[string]TextAction{
+	"keep":         TextKeep,
+	"blank":        TextBlank,
+	"delete":       TextDelete,
+	"pseudonymize": TextPseudonymize,
+}
+
+// ParseTextActions は "COMMENT=keep,UID=delete" の形式の設定を読む
+// 指定しなかったタグは DefaultTextActions の扱い方になる
+func ParseTextActions(s string) (map[byte]TextAction, error) {
+	actions := make(map[byte]TextAction, len(DefaultTextActions))
+	for code, action := range DefaultTextActions {
+		actions[code] = action
+	}
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid text action %q", item)
+		}
+		code, ok := textTagNames[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown text tag %q", name)
+		}
+		action, ok := textActionNames[strings.ToLower(strings.TrimSpace(value))]
+		if !ok {
+			return nil, fmt.Errorf("unknown text action %q", value)
+		}
+		actions[code] = action
+	}
+	return actions, nil
+}
+
+// scrubText は自由記述タグを actions に従って処理する
+// key が空の場合、TextPseudonymize は TextDelete として扱う
+func scrubText(f *File, actions map[byte]TextAction, key []byte) {
+	for code, action := range actions {
+		if action == TextPseudonymize && len(key) == 0 {
+			action = TextDelete
+		}
+
+		switch action {
+		case TextDelete:
+			f.Remove(code)
+		case TextBlank, TextPseudonymize:
+			f.Walk(func(tag *Tag) bool {
+				if tag.Code != code {
+					return true
+				}
+				if action == TextBlank {
+					tag.Contents = []byte{}
+				} else {
+					tag.Contents = []byte(pseudonymize(tag.Contents, key))
+				}
+				return true
+			})
+		}
+	}
+}
+
+// pseudonymize は key を使った HMAC-SHA256 で値を置き換える
+// 同じ値は同じ仮名になるため、同じ UID を持つ記録どうしの対応は保たれる
+func pseudonymize(value, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(value)
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
@@ -0,0 +1,76 @@
+package mfer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// DecodeTime は TIME タグの内容を読む
+// 年 2バイト, 月, 日, 時, 分, 秒 各1バイトに続き、ミリ秒 2バイト, マイクロ秒 2バイトが省略可能で並ぶ
+func DecodeTime(contents []byte, order binary.ByteOrder) (time.Time, error) {
+	if len(contents) != 7 && len(contents) != 9 && len(contents) != 11 {
+		return time.Time{}, fmt.Errorf("%w: TIME length %d", ErrBadLength, len(contents))
+	}
+
+	var nsec int
+	if len(contents) >= 9 {
+		nsec += int(order.Uint16(contents[7:9])) * int(time.Millisecond)
+	}
+	if len(contents) == 11 {
+		nsec += int(order.Uint16(contents[9:11])) * int(time.Microsecond)
+	}
+
+	t := time.Date(
+		int(order.Uint16(contents[0:2])),
+		time.Month(contents[2]),
+		int(contents[3]),
+		int(contents[4]),
+		int(contents[5]),
+		int(contents[6]),
+		nsec,
+		time.UTC,
+	)
+	return t, nil
+}
+
+// EncodeTime は TIME タグの内容を size バイト(7, 9, 11)で書く
+func EncodeTime(t time.Time, size int, order binary.ByteOrder) []byte {
+	b := make([]byte, size)
+	order.PutUint16(b[0:2], uint16(t.Year()))
+	b[2] = byte(t.Month())
+	b[3] = byte(t.Day())
+	b[4] = byte(t.Hour())
+	b[5] = byte(t.Minute())
+	b[6] = byte(t.Second())
+	if size >= 9 {
+		order.PutUint16(b[7:9], uint16(t.Nanosecond()/int(time.Millisecond)))
+	}
+	if size >= 11 {
+		order.PutUint16(b[9:11], uint16(t.Nanosecond()%int(time.Millisecond)/int(time.Microsecond)))
+	}
+	return b
+}
+
+// shiftTimes はすべての TIME タグの日付を days 日ずらす
+// EVENT と VALUE の位置は記録開始からのサンプル位置で表されるため、TIME をずらせば相対的な間隔は保たれる
+// EVENT や VALUE と同じ GROUP に置かれた日時(TIME)も同じ日数だけずらす
+// 内容を解釈できない TIME はタグごと削除する
+func shiftTimes(f *File, days int, order binary.ByteOrder) {
+	invalid := false
+	f.Walk(func(tag *Tag) bool {
+		if tag.Code != TIME {
+			return true
+		}
+		t, err := DecodeTime(tag.Contents, order)
+		if err != nil {
+			invalid = true
+			return true
+		}
+		tag.Contents = EncodeTime(t.AddDate(0, 0, days), len(tag.Contents), order)
+		return true
+	})
+	if invalid {
+		f.Remove(TIME)
+	}
+}
@@ -0,0 +1,334 @@
+package mfer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// DATA_TYPE タグで指定されるサンプルの型
+const (
+	DataTypeInt16    = 0
+	DataTypeUint16   = 1
+	DataTypeInt32    = 2
+	DataTypeUint8    = 3
+	DataTypeStatus16 = 4
+	DataTypeInt8     = 5
+	DataTypeFloat32  = 7
+	DataTypeFloat64  = 8
+)
+
+// INTERVAL タグの単位
+const (
+	intervalUnitHz     = 0
+	intervalUnitSecond = 1
+)
+
+// SENSITIVITY タグの単位 (ボルト以外の単位は物理量への換算のみ行う)
+const sensitivityUnitVolt = 0
+
+// maxChannels は扱うチャネル数の上限
+const maxChannels = 0xffff
+
+var ErrUnsupportedWaveform = errors.New("unsupported waveform")
+
+// leadNames は LDN タグの誘導コードと誘導名の対応
+var leadNames = map[int]string{
+	1:  "I",
+	2:  "II",
+	3:  "V1",
+	4:  "V2",
+	5:  "V3",
+	6:  "V4",
+	7:  "V5",
+	8:  "V6",
+	61: "III",
+	62: "aVR",
+	63: "aVL",
+	64: "aVF",
+}
+
+// Waveform は MWF ファイルから取り出した波形を表す
+type Waveform struct {
+	SamplingRate float64 // Hz
+	Channels     []Channel
+}
+
+// Channel は1チャネル分の波形を表す
+type Channel struct {
+	LeadCode   int
+	Lead       string    // 誘導名 (I, II, V1 など)
+	Unit       byte      // SENSITIVITY タグの単位コード
+	Resolution float64   // 1 LSB あたりの物理量 (ボルトの場合は µV)
+	Offset     float64   // ゼロレベルを表す生の値
+	Raw        []float64 // 生の値
+	Samples    []float64 // 物理量 (ボルトの場合は µV)、NULL タグの値と一致したサンプルは NaN
+}
+
+// sampling はチャネルごとのサンプリング設定
+type sampling struct {
+	leadCode   int
+	lead       string
+	dataType   int
+	unit       byte
+	resolution float64
+	offset     []byte
+	null       []byte
+}
+
+// Waveform は DATA タグの波形をチャネルごとの物理量に変換する
+// 圧縮されている場合は展開してから読む
+func (f *File) Waveform() (*Waveform, error) {
+	order := f.ByteOrder()
+
+	// MFER の既定値: 16bit 符号付き整数, 1µV, 1ms, ブロック長 1, 1チャネル
+	base := sampling{dataType: DataTypeInt16, resolution: 1}
+	rate := 1000.0
+	block, channels, sequences := 1, 1, -1
+
+	for _, tag := range f.Tags {
+		var err error
+		switch tag.Code {
+		case INTERVAL:
+			rate, err = decodeRate(tag.Contents, order)
+		case BLOCK:
+			block, err = decodeCount(tag.Contents, order)
+		case CHANNEL:
+			channels, err = decodeCount(tag.Contents, order)
+		case SEQUENCE:
+			sequences, err = decodeCount(tag.Contents, order)
+		case DATA, COMPRESSION:
+			// WaveformData で読む
+		default:
+			err = base.apply(tag, order)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := f.WaveformData()
+	if err != nil {
+		return nil, err
+	}
+
+	if block <= 0 || channels <= 0 || channels > maxChannels {
+		return nil, fmt.Errorf("%w: block %d, channels %d", ErrUnsupportedWaveform, block, channels)
+	}
+
+	// チャネル属性で上書きされた設定
+	settings := make([]sampling, channels)
+	for i := range settings {
+		settings[i] = base
+	}
+	for _, tag := range f.Tags {
+		if tag.Code != CHANNEL_ATTRIBUTE {
+			continue
+		}
+		if tag.Channel >= channels {
+			return nil, fmt.Errorf("%w: attribute for channel %d of %d", ErrUnsupportedWaveform, tag.Channel, channels)
+		}
+		for _, child := range tag.Children {
+			switch child.Code {
+			case INTERVAL, BLOCK:
+				return nil, fmt.Errorf("%w: per-channel sampling rate", ErrUnsupportedWaveform)
+			}
+			if err := settings[tag.Channel].apply(child, order); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// 1シーケンスあたりのバイト数
+	frameSize := 0
+	for _, s := range settings {
+		size := dataTypeSize(s.dataType)
+		if size == 0 {
+			return nil, fmt.Errorf("%w: data type %d", ErrUnsupportedWaveform, s.dataType)
+		}
+		frameSize += size * block
+	}
+	if sequences < 0 {
+		sequences = len(data) / frameSize
+	}
+	if sequences > len(data)/frameSize {
+		return nil, fmt.Errorf("%w: %d sequences of %d bytes but DATA has %d bytes", ErrTruncated, sequences, frameSize, len(data))
+	}
+
+	w := &Waveform{SamplingRate: rate, Channels: make([]Channel, channels)}
+	for i, s := range settings {
+		w.Channels[i] = Channel{
+			LeadCode:   s.leadCode,
+			Lead:       s.lead,
+			Unit:       s.unit,
+			Resolution: s.resolution,
+			Offset:     decodeValue(s.offset, s.dataType, order),
+			Raw:        make([]float64, 0, sequences*block),
+			Samples:    make([]float64, 0, sequences*block),
+		}
+		if w.Channels[i].Lead == "" {
+			w.Channels[i].Lead = fmt.Sprintf("ch%d", i+1)
+		}
+	}
+
+	// シーケンスごとに、チャネル順にブロック長分のサンプルが並ぶ
+	pos := 0
+	for seq := 0; seq < sequences; seq++ {
+		for i, s := range settings {
+			size := dataTypeSize(s.dataType)
+			ch := &w.Channels[i]
+			for n := 0; n < block; n++ {
+				b := data[pos : pos+size]
+				pos += size
+
+				raw := decodeSample(b, s.dataType, order)
+				ch.Raw = append(ch.Raw, raw)
+				if s.null != nil && string(b) == string(s.null) {
+					ch.Samples = append(ch.Samples, math.NaN())
+					continue
+				}
+				ch.Samples = append(ch.Samples, (raw-ch.Offset)*ch.Resolution)
+			}
+		}
+	}
+	return w, nil
+}
+
+// Equal は2つの波形のサンプリング周波数、誘導、生の値が一致するかを返す
+func (w *Waveform) Equal(other *Waveform) bool {
+	if w.SamplingRate != other.SamplingRate || len(w.Channels) != len(other.Channels) {
+		return false
+	}
+	for i, ch := range w.Channels {
+		o := other.Channels[i]
+		if ch.LeadCode != o.LeadCode || ch.Resolution != o.Resolution || ch.Offset != o.Offset || len(ch.Raw) != len(o.Raw) {
+			return false
+		}
+		for n := range ch.Raw {
+			if ch.Raw[n] != o.Raw[n] && !(math.IsNaN(ch.Raw[n]) && math.IsNaN(o.Raw[n])) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// apply はサンプリングに関するタグの設定を反映する
+func (s *sampling) apply(tag *Tag, order binary.ByteOrder) error {
+	switch tag.Code {
+	case DATA_TYPE:
+		if len(tag.Contents) != 1 {
+			return &Error{Offset: tag.Offset, Code: tag.Code, Err: ErrBadLength}
+		}
+		s.dataType = int(tag.Contents[0])
+	case SENSITIVITY:
+		unit, value, err := decodeScaled(tag.Contents, order)
+		if err != nil {
+			return &Error{Offset: tag.Offset, Code: tag.Code, Err: err}
+		}
+		s.unit = unit
+		s.resolution = value
+		if unit == sensitivityUnitVolt {
+			s.resolution = value * 1e6
+		}
+	case OFFSET:
+		s.offset = tag.Contents
+	case NULL:
+		s.null = tag.Contents
+	case LDN:
+		if len(tag.Contents) == 0 {
+			return &Error{Offset: tag.Offset, Code: tag.Code, Err: ErrBadLength}
+		}
+		if len(tag.Contents) == 1 {
+			s.leadCode = int(tag.Contents[0])
+		} else {
+			s.leadCode = int(order.Uint16(tag.Contents[:2]))
+		}
+		s.lead = LeadName(s.leadCode)
+	}
+	return nil
+}
+
+// decodeScaled は 単位 1バイト, 指数 1バイト(符号付き), 仮数 1〜4バイト の値を読む
+func decodeScaled(contents []byte, order binary.ByteOrder) (byte, float64, error) {
+	if len(contents) < 3 || len(contents) > 6 {
+		return 0, 0, fmt.Errorf("%w: scaled value length %d", ErrBadLength, len(contents))
+	}
+	exponent := int8(contents[1])
+	mantissa := readUint(contents[2:], order)
+	return contents[0], float64(mantissa) * math.Pow10(int(exponent)), nil
+}
+
+// decodeRate は INTERVAL タグの内容をサンプリング周波数(Hz)に変換する
+func decodeRate(contents []byte, order binary.ByteOrder) (float64, error) {
+	unit, value, err := decodeScaled(contents, order)
+	if err != nil {
+		return 0, err
+	}
+	if value == 0 {
+		return 0, fmt.Errorf("%w: sampling interval is zero", ErrUnsupportedWaveform)
+	}
+	switch unit {
+	case intervalUnitHz:
+		return value, nil
+	case intervalUnitSecond:
+		return 1 / value, nil
+	}
+	return 0, fmt.Errorf("%w: sampling unit %d", ErrUnsupportedWaveform, unit)
+}
+
+func decodeCount(contents []byte, order binary.ByteOrder) (int, error) {
+	if len(contents) == 0 || len(contents) > 4 {
+		return 0, fmt.Errorf("%w: count length %d", ErrBadLength, len(contents))
+	}
+	return int(readUint(contents, order)), nil
+}
+
+func dataTypeSize(dataType int) int {
+	switch dataType {
+	case DataTypeInt16, DataTypeUint16, DataTypeStatus16:
+		return 2
+	case DataTypeInt32, DataTypeFloat32:
+		return 4
+	case DataTypeUint8, DataTypeInt8:
+		return 1
+	case DataTypeFloat64:
+		return 8
+	}
+	return 0
+}
+
+func decodeSample(b []byte, dataType int, order binary.ByteOrder) float64 {
+	switch dataType {
+	case DataTypeInt16:
+		return float64(int16(order.Uint16(b)))
+	case DataTypeUint16, DataTypeStatus16:
+		return float64(order.Uint16(b))
+	case DataTypeInt32:
+		return float64(int32(order.Uint32(b)))
+	case DataTypeUint8:
+		return float64(b[0])
+	case DataTypeInt8:
+		return float64(int8(b[0]))
+	case DataTypeFloat32:
+		return float64(math.Float32frombits(order.Uint32(b)))
+	case DataTypeFloat64:
+		return math.Float64frombits(order.Uint64(b))
+	}
+	return 0
+}
+
+// decodeValue は OFFSET などサンプルと同じ型で書かれた値を読む
+func decodeValue(b []byte, dataType int, order binary.ByteOrder) float64 {
+	if len(b) == 0 {
+		return 0
+	}
+	if len(b) == dataTypeSize(dataType) {
+		return decodeSample(b, dataType, order)
+	}
+	if len(b) <= 4 {
+		return float64(readUint(b, order))
+	}
+	return 0
+}
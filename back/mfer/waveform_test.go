@@ -0,0 +1,86 @@
+package mfer
+
+import (
+	"math"
+	"testing"
+)
+
+func TestWaveform(t *testing.T) {
+	// テストデータ
+	testData := []byte{
+		// バイトオーダー (リトルエンディアン)
+		0x01, 0x01, 0x01,
+		// サンプリング間隔 (2ms)
+		0x0b, 0x03, 0x01, 0xfd, 0x02,
+		// 感度 (2.5µV)
+		0x0c, 0x03, 0x00, 0xf9, 0x19,
+		// ブロック長, チャネル数, シーケンス数
+		0x04, 0x01, 0x02,
+		0x05, 0x01, 0x02,
+		0x06, 0x01, 0x02,
+		// チャネル1, 2の属性 (誘導 I, II)
+		0x3f, 0x00, 0x03, 0x09, 0x01, 0x01,
+		0x3f, 0x01, 0x03, 0x09, 0x01, 0x02,
+		// 波形データ
+		0x1e, 0x10,
+		0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x04, 0x00,
+		0x05, 0x00, 0x06, 0x00, 0xf9, 0xff, 0x08, 0x00,
+		// 終了
+		0x80,
+	}
+
+	f, err := Parse(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := f.Waveform()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.SamplingRate != 500 {
+		t.Errorf("unexpected sampling rate, got: %v, want: %v", w.SamplingRate, 500)
+	}
+	if len(w.Channels) != 2 {
+		t.Fatalf("unexpected number of channels, got: %d, want: %d", len(w.Channels), 2)
+	}
+
+	expected := []struct {
+		lead    string
+		samples []float64
+	}{
+		{"I", []float64{2.5, 5, 12.5, 15}},
+		{"II", []float64{7.5, 10, -17.5, 20}},
+	}
+	for i, want := range expected {
+		ch := w.Channels[i]
+		if ch.Lead != want.lead {
+			t.Errorf("unexpected lead, got: %s, want: %s", ch.Lead, want.lead)
+		}
+		if len(ch.Samples) != len(want.samples) {
+			t.Fatalf("unexpected number of samples, got: %d, want: %d", len(ch.Samples), len(want.samples))
+		}
+		for n, v := range want.samples {
+			if math.Abs(ch.Samples[n]-v) > 1e-9 {
+				t.Errorf("unexpected sample %d of %s, got: %v, want: %v", n, ch.Lead, ch.Samples[n], v)
+			}
+		}
+	}
+
+	// 匿名化しても波形は変わらない
+	anonymized, err := AnonymizeWithOptions(testData, Options{PseudonymID: "abcd", DateShiftDays: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	af, err := Parse(anonymized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aw, err := af.Waveform()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.Equal(aw) {
+		t.Error("waveform changed by anonymization")
+	}
+}
@@ -36,6 +36,16 @@ func SetupDB(dsn string) error {
 			name TEXT,
 			birthtime TEXT
 		)`,
+		`CREATE TABLE IF NOT EXISTS processed_files(
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			sha256 TEXT NOT NULL,
+			name TEXT NOT NULL,
+			status TEXT NOT NULL,
+			moved_to TEXT NOT NULL,
+			message TEXT,
+			processed_at TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS processed_files_sha256 ON processed_files(sha256)`,
 	}
 	for _, query := range queries {
 		_, err = db.Exec(query)
@@ -179,3 +189,47 @@ func GetHashedIDByExportID(db *sql.DB, exportID string) (string, error) {
 	}
 	return hashedID, nil
 }
+
+func GetPatientIDByExportID(db *sql.DB, exportID string) (string, error) {
+	selectQuery := `SELECT patient_id FROM ecgs WHERE export_id = ?`
+	var patientID string
+	err := db.QueryRow(selectQuery, exportID).Scan(&patientID)
+	if err != nil {
+		return "", fmt.Errorf("failed to select patient ID from ecgs: %w", err)
+	}
+	return patientID, nil
+}
+
+// GetECGsByPatientID は患者IDが同じ記録をすべて返す
+func GetECGsByPatientID(db *sql.DB, patientID string) ([]ECG, error) {
+	query := `SELECT id, patient_id, hashed_id, export_id, name, birthtime FROM ecgs WHERE patient_id = ?`
+	rows, err := db.Query(query, patientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select ecgs: %w", err)
+	}
+	defer rows.Close()
+
+	var ecgs []ECG
+	for rows.Next() {
+		var ecg ECG
+		var name, birthtime sql.NullString
+		if err := rows.Scan(&ecg.Id, &ecg.PatientID, &ecg.HashedId, &ecg.ExportID, &name, &birthtime); err != nil {
+			return nil, fmt.Errorf("failed to scan ecg: %w", err)
+		}
+		ecg.Name, ecg.Birthtime = name.String, birthtime.String
+		ecgs = append(ecgs, ecg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %w", err)
+	}
+	return ecgs, nil
+}
+
+// Backup はデータベースの内容を path に書き出す
+// 書き込み中でも一貫した内容を書き出せるよう VACUUM INTO を使う
+func Backup(db *sql.DB, path string) error {
+	if _, err := db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ProcessedFile は監視フォルダから取り込んだファイルの記録
+type ProcessedFile struct {
+	SHA256      string // 元のファイルの内容のハッシュ
+	Name        string // 元のファイル名
+	Status      string // anonymized, failed, duplicate など
+	MovedTo     string // 元のファイルの移動先
+	Message     string
+	ProcessedAt time.Time
+}
+
+// PutProcessedFile はファイルの処理結果を記録する
+func PutProcessedFile(db *sql.DB, file ProcessedFile) error {
+	query := `INSERT INTO processed_files (sha256, name, status, moved_to, message, processed_at) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := db.Exec(query, file.SHA256, file.Name, file.Status, file.MovedTo, file.Message, file.ProcessedAt.Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("failed to insert processed file: %w", err)
+	}
+	return nil
+}
+
+// GetProcessedFiles は同じ内容のファイルの処理結果を古い順に返す
+func GetProcessedFiles(db *sql.DB, sha256 string) ([]ProcessedFile, error) {
+	query := `SELECT sha256, name, status, moved_to, message, processed_at FROM processed_files WHERE sha256 = ? ORDER BY id`
+	rows, err := db.Query(query, sha256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select processed files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []ProcessedFile
+	for rows.Next() {
+		var file ProcessedFile
+		var message sql.NullString
+		var processedAt string
+		if err := rows.Scan(&file.SHA256, &file.Name, &file.Status, &file.MovedTo, &message, &processedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan processed file: %w", err)
+		}
+		file.Message = message.String
+		if file.ProcessedAt, err = time.Parse(time.RFC3339, processedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse processed_at: %w", err)
+		}
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %w", err)
+	}
+	return files, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/shikidalab/anonymize-ecg/controller"
+	"github.com/shikidalab/anonymize-ecg/model"
+)
+
+// logWriter は serve でも gin のログを標準出力とログファイルの両方に書くために使う
+var logWriter io.Writer = os.Stdout
+
+// runServe は serve サブコマンドを実行する
+func runServe(args []string) error {
+	s := newSettings("serve", "serve [flags]")
+	port := s.env("port", "PORT", "port to listen on (default 8080)")
+	frontOrigin := s.env("front-origin", "FRONT_ORIGIN", "origin of the frontend allowed by CORS")
+	s.env("save-dir", "SAVE_DIR", "directory where exported CSV files are saved")
+	s.anonymizeFlags()
+	if err := s.parse(args); err != nil {
+		return err
+	}
+
+	// dbの立ち上げ
+	if err := model.SetupDB(os.Getenv("DSN")); err != nil {
+		return err
+	}
+
+	// ginのログ出力先をstdoutとlogファイルの両方に指定
+	gin.DefaultWriter = logWriter
+	gin.DefaultErrorWriter = logWriter
+
+	// httpサーバのセットアップ
+	router := gin.Default()
+
+	router.Use(gin.Logger())
+	router.Use(gin.Recovery())
+	router.Use(cors.New(cors.Config{
+		AllowOrigins:     []string{*frontOrigin}, // フロントエンドのオリジン
+		AllowMethods:     []string{"GET", "POST"},
+		AllowHeaders:     []string{"Origin", "Content-Type"},
+		AllowCredentials: true,
+	}))
+
+	// サーバのルーティング設定
+	router.GET("/", controller.GetTop)
+	router.GET("/upload", controller.AnonymizeECG)
+	router.GET("/download-csv", controller.ExportCSV)
+
+	// サーバの起動
+	addr := ":8080"
+	if *port != "" {
+		addr = ":" + *port
+	}
+	return router.Run(addr)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shikidalab/anonymize-ecg/controller"
+	"github.com/shikidalab/anonymize-ecg/verify"
+)
+
+// runVerify は verify サブコマンドを実行する
+func runVerify(args []string) error {
+	s := newSettings("verify", "verify -original DIR -anonymized DIR|FILE.zip")
+	original := s.flags.String("original", "", "directory containing the original files (read recursively)")
+	anonymized := s.flags.String("anonymized", "", "directory or ZIP file of the anonymized files")
+	if err := s.parse(args); err != nil {
+		return err
+	}
+	if *original == "" || *anonymized == "" {
+		s.flags.Usage()
+		return errors.New("-original and -anonymized are required")
+	}
+
+	originals, err := readFiles(*original)
+	if err != nil {
+		return err
+	}
+	var identifiers []verify.Identifier
+	for _, file := range originals {
+		ids, err := verify.Extract(file.Name, file.Content)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file.Name, err)
+			continue
+		}
+		identifiers = append(identifiers, ids...)
+	}
+
+	outputs, err := readFiles(*anonymized)
+	if err != nil {
+		return err
+	}
+	var findings []verify.Finding
+	for _, file := range outputs {
+		findings = append(findings, verify.Scan(file.Name, file.Content, identifiers)...)
+	}
+	for _, finding := range findings {
+		fmt.Println(finding)
+	}
+	fmt.Printf("scanned: %d, findings: %d\n", len(outputs), len(findings))
+	if len(findings) > 0 {
+		return verify.ErrResidualPII
+	}
+	return nil
+}
+
+// readFiles はディレクトリ以下のファイル、または ZIP ファイルの中身を読む
+// ファイル名はディレクトリまたは ZIP ファイルからの相対パス
+func readFiles(path string) ([]controller.File, error) {
+	var files []controller.File
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		reader, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		for _, f := range reader.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			content, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, controller.File{Name: f.Name, Content: content})
+		}
+		return files, nil
+	}
+
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, controller.File{Name: name, Content: content})
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,330 @@
+package verify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+	textunicode "golang.org/x/text/encoding/unicode"
+
+	"github.com/shikidalab/anonymize-ecg/mfer"
+	"github.com/shikidalab/anonymize-ecg/xml"
+)
+
+// minASCIILength は照合する ASCII の識別子の最小の文字数
+// 短い値は波形やヘッダの数値と偶然一致しやすいため照合しない
+const minASCIILength = 4
+
+// 識別子の種類
+const (
+	KindPatientID = "patient ID"
+	KindName      = "name"
+	KindBirthDate = "birth date"
+)
+
+var ErrResidualPII = errors.New("identifying information remains in output")
+
+// ResidualPIIError は出力に識別子が残っていたときのエラー
+// errors.Is で ErrResidualPII と比較できる
+type ResidualPIIError struct {
+	Findings []Finding
+}
+
+func (e *ResidualPIIError) Error() string {
+	if len(e.Findings) == 0 {
+		return ErrResidualPII.Error()
+	}
+	return fmt.Sprintf("%v: %s (%d findings)", ErrResidualPII, e.Findings[0], len(e.Findings))
+}
+
+func (e *ResidualPIIError) Unwrap() error {
+	return ErrResidualPII
+}
+
+// Identifier は匿名化前のファイルから読んだ、出力に残ってはいけない値
+type Identifier struct {
+	Kind  string
+	Value string
+
+	binary bool // Value が文字列ではなくバイト列を表すかどうか
+}
+
+// Finding は出力に残っていた識別子の位置
+// ログに残せるよう、識別子の値そのものは持たない
+type Finding struct {
+	File     string `json:"file"`
+	Source   string `json:"source,omitempty"` // File を作った匿名化前のファイル
+	Offset   int64  `json:"offset"`
+	Kind     string `json:"kind"`
+	Encoding string `json:"encoding"`
+}
+
+func (f Finding) String() string {
+	if f.Source != "" {
+		return fmt.Sprintf("%s (from %s): %s found at offset %d (%s)", f.File, f.Source, f.Kind, f.Offset, f.Encoding)
+	}
+	return fmt.Sprintf("%s: %s found at offset %d (%s)", f.File, f.Kind, f.Offset, f.Encoding)
+}
+
+// Extract は匿名化前のファイルから照合する識別子を読む
+// 対応していない種類のファイルの場合は何も返さない
+func Extract(name string, data []byte) ([]Identifier, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".xml":
+		return fromXML(data)
+	case ".mwf":
+		return fromMWF(data)
+	}
+	return nil, nil
+}
+
+func fromXML(data []byte) ([]Identifier, error) {
+	info, err := xml.GetPersonalInfo(data)
+	if err != nil && !errors.Is(err, xml.ErrNoPatientID) {
+		return nil, err
+	}
+	return Identifiers(info.PatientID, []string{info.Name, info.GivenName}, info.BirthTime), nil
+}
+
+// Identifiers は患者ID、氏名の各部分 (姓、名など)、生年月日から照合する識別子を作る
+// 生年月日は XML に書かれる形式で渡す
+func Identifiers(patientID string, names []string, birthTime string) []Identifier {
+	ids := []Identifier{{Kind: KindPatientID, Value: patientID}}
+	for _, name := range names {
+		ids = append(ids, nameParts(name)...)
+	}
+	if birth, err := xml.ParseDate(birthTime); err == nil {
+		ids = append(ids, dateForms(birth)...)
+	}
+	return compact(ids)
+}
+
+func fromMWF(data []byte) ([]Identifier, error) {
+	f, err := mfer.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []Identifier
+	if tag := f.Find(mfer.P_ID); tag != nil {
+		id, err := f.DecodeString(tag.Contents)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, Identifier{Kind: KindPatientID, Value: strings.Trim(id, " \x00")})
+	}
+	if tag := f.Find(mfer.P_NAME); tag != nil {
+		name, err := f.DecodeString(tag.Contents)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, nameParts(name)...)
+	}
+	if tag := f.Find(mfer.P_AGE); tag != nil {
+		age, err := mfer.DecodeAge(tag.Contents, f.ByteOrder())
+		if err == nil && age.BirthYear != 0 && age.BirthMonth != 0 && age.BirthDay != 0 {
+			birth := time.Date(int(age.BirthYear), time.Month(age.BirthMonth), int(age.BirthDay), 0, 0, 0, 0, time.UTC)
+			ids = append(ids, dateForms(birth)...)
+			// P_AGE と同じ形式 (年2バイト、月、日) のバイト列
+			for _, order := range []binary.AppendByteOrder{binary.BigEndian, binary.LittleEndian} {
+				b := order.AppendUint16(nil, age.BirthYear)
+				b = append(b, age.BirthMonth, age.BirthDay)
+				ids = append(ids, Identifier{Kind: KindBirthDate, Value: string(b), binary: true})
+			}
+		}
+	}
+	return compact(ids), nil
+}
+
+// nameParts は氏名全体と、区切り文字で分けた各部分を返す
+func nameParts(name string) []Identifier {
+	ids := []Identifier{{Kind: KindName, Value: strings.TrimSpace(name)}}
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, part := range parts {
+		ids = append(ids, Identifier{Kind: KindName, Value: part})
+	}
+	return ids
+}
+
+// dateForms は日付を書き表す主な形式を返す
+func dateForms(t time.Time) []Identifier {
+	var ids []Identifier
+	for _, layout := range []string{"20060102", "2006-01-02", "2006/01/02", "2006/1/2", "01-02-2006", "01/02/2006"} {
+		ids = append(ids, Identifier{Kind: KindBirthDate, Value: t.Format(layout)})
+	}
+	return ids
+}
+
+// compact は照合に使えない短い値と重複を取り除く
+func compact(ids []Identifier) []Identifier {
+	var result []Identifier
+	seen := make(map[Identifier]bool)
+	for _, id := range ids {
+		if seen[id] || !long(id) {
+			continue
+		}
+		seen[id] = true
+		result = append(result, id)
+	}
+	return result
+}
+
+func long(id Identifier) bool {
+	if id.binary {
+		return len(id.Value) > 0
+	}
+	for i := 0; i < len(id.Value); i++ {
+		if id.Value[i] >= utf8.RuneSelf {
+			// 日本語の氏名は2文字のこともある
+			return utf8.RuneCountInString(id.Value) >= 2
+		}
+	}
+	return len(id.Value) >= minASCIILength
+}
+
+// Scan は出力ファイルに識別子が残っていないかを調べる
+// XML はテキストと属性値を (波形の digits を除いて)、MWF は波形以外のタグの内容を、
+// EDF はヘッダを、それ以外のファイルは全体のバイト列を、UTF-8、UTF-16、Shift_JIS で照合する
+// WFDB の信号ファイル (.dat) と EDF のデータレコードはサンプル値が偶然一致することがあるため照合しない
+func Scan(name string, data []byte, ids []Identifier) []Finding {
+	if len(ids) == 0 {
+		return nil
+	}
+	patterns := patternsOf(ids)
+
+	var findings []Finding
+	add := func(base int64, matches []match) {
+		for _, m := range matches {
+			findings = append(findings, Finding{File: name, Offset: base + m.offset, Kind: m.kind, Encoding: m.encoding})
+		}
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".xml":
+		err := xml.WalkText(data, func(offset int64, path []string, text string) {
+			if len(path) > 0 && path[len(path)-1] == "digits" {
+				return
+			}
+			add(offset, scanBytes([]byte(text), patterns))
+		})
+		if err == nil {
+			return findings
+		}
+		findings = nil
+	case ".mwf":
+		f, err := mfer.Parse(data)
+		if err == nil {
+			f.Walk(func(tag *mfer.Tag) bool {
+				if tag.Code != mfer.DATA {
+					add(int64(tag.Offset), scanBytes(tag.Contents, patterns))
+				}
+				return true
+			})
+			return findings
+		}
+	case ".dat":
+		return nil
+	case ".edf":
+		data = data[:edfHeaderSize(data)]
+	}
+	add(0, scanBytes(data, patterns))
+	return findings
+}
+
+type pattern struct {
+	kind     string
+	encoding string
+	value    []byte
+	boundary bool // 前後が英数字でない場合のみ一致とするかどうか
+}
+
+type match struct {
+	offset   int64
+	kind     string
+	encoding string
+}
+
+func patternsOf(ids []Identifier) []pattern {
+	var patterns []pattern
+	seen := make(map[string]bool)
+	add := func(p pattern) {
+		key := p.kind + "\x00" + string(p.value)
+		if len(p.value) == 0 || seen[key] {
+			return
+		}
+		seen[key] = true
+		patterns = append(patterns, p)
+	}
+
+	utf16le := textunicode.UTF16(textunicode.LittleEndian, textunicode.IgnoreBOM)
+	utf16be := textunicode.UTF16(textunicode.BigEndian, textunicode.IgnoreBOM)
+	for _, id := range ids {
+		if id.binary {
+			add(pattern{kind: id.Kind, encoding: "binary", value: []byte(id.Value)})
+			continue
+		}
+		for _, value := range []string{id.Value, strings.ToUpper(id.Value), strings.ToLower(id.Value)} {
+			add(pattern{kind: id.Kind, encoding: "UTF-8", value: []byte(value), boundary: true})
+			if b, err := utf16le.NewEncoder().Bytes([]byte(value)); err == nil {
+				add(pattern{kind: id.Kind, encoding: "UTF-16LE", value: b})
+			}
+			if b, err := utf16be.NewEncoder().Bytes([]byte(value)); err == nil {
+				add(pattern{kind: id.Kind, encoding: "UTF-16BE", value: b})
+			}
+			if b, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte(value)); err == nil {
+				add(pattern{kind: id.Kind, encoding: "Shift_JIS", value: b, boundary: true})
+			}
+		}
+	}
+	return patterns
+}
+
+func scanBytes(data []byte, patterns []pattern) []match {
+	var matches []match
+	for _, p := range patterns {
+		for start := 0; ; {
+			i := bytes.Index(data[start:], p.value)
+			if i < 0 {
+				break
+			}
+			i += start
+			end := i + len(p.value)
+			if !p.boundary || (!isAlnum(data, i-1) && !isAlnum(data, end)) {
+				matches = append(matches, match{offset: int64(i), kind: p.kind, encoding: p.encoding})
+			}
+			start = i + 1
+		}
+	}
+	return matches
+}
+
+func isAlnum(data []byte, i int) bool {
+	if i < 0 || i >= len(data) {
+		return false
+	}
+	b := data[i]
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// edfHeaderSize は EDF のヘッダのバイト数を返す
+// ヘッダのバイト数の項目が読めない場合は全体の長さを返す
+func edfHeaderSize(data []byte) int {
+	if len(data) < 256 {
+		return len(data)
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(string(data[184:192])))
+	if err != nil || size < 256 || size > len(data) {
+		return len(data)
+	}
+	return size
+}
@@ -0,0 +1,181 @@
+package verify
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+	textunicode "golang.org/x/text/encoding/unicode"
+
+	"github.com/shikidalab/anonymize-ecg/mfer"
+	"github.com/shikidalab/anonymize-ecg/xml"
+)
+
+var testXML = []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<AnnotatedECG xmlns="urn:hl7-org:v3">
+  <id extension="ECG-0001"/>
+  <subject>
+    <patient>
+      <patientPatient>
+        <id extension="PID-1001"/>
+        <name><given>Taro</given><family>Yamada</family></name>
+        <birthTime value="19841123"/>
+      </patientPatient>
+    </patient>
+  </subject>
+  <component>
+    <series>
+      <component>
+        <sequenceSet>
+          <component>
+            <sequence>
+              <code code="MDC_ECG_LEAD_I"/>
+              <value><digits>1001 19841123 -1001</digits></value>
+            </sequence>
+          </component>
+        </sequenceSet>
+      </component>
+    </series>
+  </component>
+</AnnotatedECG>`)
+
+// 患者名「光電　花子^ｺｳﾃﾞﾝ ﾊﾅｺ」、患者ID「11237000051」、生年月日 1984/11/23 の MWF
+var testMWF = []byte{
+	0x01, 0x01, 0x01,
+	0x03, 0x08, 0x55, 0x4E, 0x49, 0x43, 0x4F, 0x44, 0x45, 0x00,
+	0x81, 0x2a, 0xe5, 0x85, 0x89, 0xe9, 0x9b, 0xbb, 0xe3, 0x80, 0x80, 0xe8, 0x8a, 0xb1, 0xe5, 0xad, 0x90, 0x5e, 0xef, 0xbd, 0xba, 0xef, 0xbd, 0xb3, 0xef, 0xbe, 0x83, 0xef, 0xbe, 0x9e, 0xef, 0xbe, 0x9d, 0x20, 0xef, 0xbe, 0x8a, 0xef, 0xbe, 0x85, 0xef, 0xbd, 0xba, 0x00,
+	0x82, 0x0b, 0x31, 0x31, 0x32, 0x33, 0x37, 0x30, 0x30, 0x30, 0x35, 0x31, 0x00,
+	0x83, 0x07, 0x16, 0xfe, 0x1f, 0xc0, 0x07, 0x0b, 0x17,
+}
+
+func TestScanXML(t *testing.T) {
+	ids, err := Extract("a_1.xml", testXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings := Scan("a_1.xml", testXML, ids)
+	kinds := make(map[string]bool)
+	for _, f := range findings {
+		kinds[f.Kind] = true
+	}
+	for _, kind := range []string{KindPatientID, KindName} {
+		if !kinds[kind] {
+			t.Errorf("%s is not found in original: %v", kind, findings)
+		}
+	}
+
+	// 匿名化後は識別子が残らず、波形の digits は照合しない
+	anonymized, err := xml.AnonymizeWithOptions(testXML, xml.Options{PreserveFormat: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findings := Scan("a_1.xml", anonymized, ids); len(findings) != 0 {
+		t.Errorf("unexpected findings: %v", findings)
+	}
+}
+
+func TestScanMWF(t *testing.T) {
+	ids, err := Extract("a_1.mwf", testMWF)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings := Scan("a_1.mwf", testMWF, ids)
+	kinds := make(map[string]bool)
+	for _, f := range findings {
+		kinds[f.Kind] = true
+	}
+	for _, kind := range []string{KindPatientID, KindName, KindBirthDate} {
+		if !kinds[kind] {
+			t.Errorf("%s is not found in original: %v", kind, findings)
+		}
+	}
+
+	anonymized, err := mfer.Anonymize(testMWF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findings := Scan("a_1.mwf", anonymized, ids); len(findings) != 0 {
+		t.Errorf("unexpected findings: %v", findings)
+	}
+}
+
+func TestScanEncodings(t *testing.T) {
+	ids := []Identifier{{Kind: KindName, Value: "光電"}, {Kind: KindPatientID, Value: "PID-1001"}}
+
+	utf16, err := textunicode.UTF16(textunicode.LittleEndian, textunicode.IgnoreBOM).NewEncoder().Bytes([]byte("PID-1001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sjis, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte("光電"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := append([]byte("header "), utf16...)
+	sjisOffset := len(data) + 1
+	data = append(append(data, ' '), sjis...)
+
+	findings := Scan("a_1.edf", data, ids)
+	if len(findings) != 2 {
+		t.Fatalf("unexpected findings: %v", findings)
+	}
+	if findings[0].Encoding != "Shift_JIS" || findings[0].Offset != int64(sjisOffset) {
+		t.Errorf("unexpected finding: %v", findings[0])
+	}
+	if findings[1].Encoding != "UTF-16LE" || findings[1].Offset != 7 {
+		t.Errorf("unexpected finding: %v", findings[1])
+	}
+
+	// 英数字に挟まれた値や短すぎる値は一致としない
+	ids = append(ids, Identifier{Kind: KindPatientID, Value: "12"})
+	if findings := Scan("a_1.hea", []byte("xPID-1001 12 1200"), compact(ids)); len(findings) != 0 {
+		t.Errorf("unexpected findings: %v", findings)
+	}
+	if findings := Scan("a_1.hea", bytes.ToLower([]byte("a pid-1001 b")), ids); len(findings) != 1 {
+		t.Errorf("lower case identifier is not found: %v", findings)
+	}
+}
+
+func TestScanSignalData(t *testing.T) {
+	ids := []Identifier{{Kind: KindPatientID, Value: "PID-1001"}}
+
+	// WFDB の信号ファイルはサンプル値だけなので照合しない
+	if findings := Scan("a_1.dat", []byte("\x00PID-1001\x00"), ids); len(findings) != 0 {
+		t.Errorf("unexpected findings in signal file: %v", findings)
+	}
+
+	// EDF はヘッダのバイト数の項目までを照合し、データレコードは照合しない
+	header := bytes.Repeat([]byte(" "), 512)
+	copy(header[8:], "PID-1001")
+	copy(header[184:], "512")
+	data := append(header, []byte("\x00PID-1001\x00")...)
+	findings := Scan("a_1.edf", data, ids)
+	if len(findings) != 1 || findings[0].Offset != 8 {
+		t.Errorf("unexpected findings in edf: %v", findings)
+	}
+}
+
+func TestExtractGivenName(t *testing.T) {
+	// 姓だけでなく名も照合する
+	for file, given := range map[string]string{"hl7.xml": "Taro", "muse.xml": "TARO", "philips.xml": "Taro"} {
+		data, err := os.ReadFile(filepath.Join("..", "xml", "testdata", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, err := Extract(file, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, id := range ids {
+			found = found || (id.Kind == KindName && id.Value == given)
+		}
+		if !found {
+			t.Errorf("%s: given name is not extracted: %+v", file, ids)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shikidalab/anonymize-ecg/controller"
+	"github.com/shikidalab/anonymize-ecg/model"
+)
+
+// runWatch は watch サブコマンドを実行する
+// 心電計が書き出すフォルダを監視し、届いたファイルを自動で匿名化する
+func runWatch(args []string) error {
+	s := newSettings("watch", "watch -dir DIR -out DIR -archive DIR -quarantine DIR [flags]")
+	dir := s.env("dir", "WATCH_DIR", "directory to watch for mwf/xml files")
+	out := s.env("out", "WATCH_OUTPUT_DIR", "directory where anonymized files are written")
+	archive := s.env("archive", "WATCH_ARCHIVE_DIR", "directory where originals are moved after anonymization")
+	quarantine := s.env("quarantine", "WATCH_QUARANTINE_DIR", "directory where originals that could not be anonymized are moved")
+	interval := s.env("interval", "WATCH_INTERVAL", "how often the directory is read (default 10s)")
+	pairWait := s.env("pair-wait", "WATCH_PAIR_WAIT", "how long to wait for the matching xml or mwf file (default 5m)")
+	secret := s.secret("secret", "ANONYMIZE_SECRET", "password used for anonymization")
+	formats := s.flags.String("formats", "", "comma-separated extra output formats for mwf files (wfdb, edf)")
+	s.anonymizeFlags()
+	if err := s.parse(args); err != nil {
+		return err
+	}
+
+	if *secret == "" {
+		return errors.New("-secret or ANONYMIZE_SECRET is required")
+	}
+	opts := controller.WatchOptions{
+		Dir:        *dir,
+		Output:     *out,
+		Archive:    *archive,
+		Quarantine: *quarantine,
+		Password:   *secret,
+	}
+	var err error
+	if opts.Interval, err = parseDuration(*interval, 10*time.Second); err != nil {
+		return fmt.Errorf("error -interval: %w", err)
+	}
+	if opts.PairWait, err = parseDuration(*pairWait, 5*time.Minute); err != nil {
+		return fmt.Errorf("error -pair-wait: %w", err)
+	}
+	for _, format := range strings.Split(*formats, ",") {
+		if format = strings.TrimSpace(format); format != "" {
+			opts.OutputFormats = append(opts.OutputFormats, format)
+		}
+	}
+
+	if err := model.SetupDB(os.Getenv("DSN")); err != nil {
+		return err
+	}
+
+	// Ctrl-C や docker stop で止める
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return controller.Watch(ctx, opts)
+}
+
+// parseDuration は空の場合に def を返す
+func parseDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration must be positive: %s", s)
+	}
+	return d, nil
+}
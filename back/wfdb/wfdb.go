@@ -0,0 +1,159 @@
+package wfdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+
+	"github.com/shikidalab/anonymize-ecg/mfer"
+)
+
+// invalidSample は WFDB の format 16 で欠損値を表す値
+const invalidSample = -32768
+
+var (
+	ErrNoChannels        = errors.New("waveform has no channels")
+	ErrInvalidRecordName = errors.New("record name must contain only letters, digits and underscores")
+)
+
+// recordName は WFDB のレコード名として使える名前
+var recordName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// File は WFDB レコードを構成する1ファイル
+type File struct {
+	Name    string
+	Content []byte
+}
+
+// Write は MFER の波形から WFDB のヘッダ(.hea)と format 16 の信号ファイル(.dat)を作る
+// record はレコード名で、英数字とアンダースコアのみを使う
+func Write(record string, w *mfer.Waveform) ([]File, error) {
+	if !recordName.MatchString(record) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRecordName, record)
+	}
+	if len(w.Channels) == 0 {
+		return nil, ErrNoChannels
+	}
+	if w.SamplingRate <= 0 {
+		return nil, fmt.Errorf("invalid sampling rate %v", w.SamplingRate)
+	}
+
+	nsamp := len(w.Channels[0].Samples)
+	signals := make([]signal, len(w.Channels))
+	for i, ch := range w.Channels {
+		if len(ch.Samples) != nsamp {
+			return nil, fmt.Errorf("channel %s has %d samples, expected %d", ch.Lead, len(ch.Samples), nsamp)
+		}
+		signals[i] = newSignal(ch)
+	}
+
+	// 各時刻のサンプルをチャネル順に並べる
+	dat := make([]byte, 0, nsamp*len(signals)*2)
+	for n := 0; n < nsamp; n++ {
+		for i := range signals {
+			dat = binary.LittleEndian.AppendUint16(dat, uint16(signals[i].digital[n]))
+		}
+	}
+
+	var hea bytes.Buffer
+	fmt.Fprintf(&hea, "%s %d %s %d\n", record, len(signals), formatFloat(w.SamplingRate), nsamp)
+	for _, s := range signals {
+		var initial int16
+		if nsamp > 0 {
+			initial = s.digital[0]
+		}
+		fmt.Fprintf(&hea, "%s.dat 16 %s(%d)%s 16 0 %d %d 0 %s\n",
+			record, formatFloat(s.gain), s.baseline, s.units, initial, s.checksum(), s.description)
+	}
+
+	return []File{
+		{Name: record + ".hea", Content: hea.Bytes()},
+		{Name: record + ".dat", Content: dat},
+	}, nil
+}
+
+// signal は WFDB の1信号分の設定と値
+type signal struct {
+	gain        float64 // 物理単位あたりの ADC 値
+	baseline    int
+	units       string
+	description string
+	digital     []int16
+}
+
+// newSignal はチャネルを WFDB の信号に変換する
+// 生の値が16bit整数に収まる場合はそのまま使い、収まらない場合は物理量から分解能を決め直す
+func newSignal(ch mfer.Channel) signal {
+	s := signal{description: ch.Lead, digital: make([]int16, len(ch.Samples))}
+
+	// ボルトの場合は mV 単位で書く
+	scale := 1.0
+	if ch.Unit == 0 {
+		s.units = "/mV"
+		scale = 1000
+	}
+
+	if fitsInt16(ch.Raw) && fitsInt16([]float64{ch.Offset}) && ch.Resolution > 0 {
+		s.gain = scale / ch.Resolution
+		s.baseline = int(ch.Offset)
+		for n, v := range ch.Raw {
+			if math.IsNaN(ch.Samples[n]) {
+				s.digital[n] = invalidSample
+				continue
+			}
+			s.digital[n] = int16(v)
+		}
+		return s
+	}
+
+	// 物理量の最大値が 32767 になるように分解能を決める
+	peak := 0.0
+	for _, v := range ch.Samples {
+		if !math.IsNaN(v) {
+			peak = math.Max(peak, math.Abs(v))
+		}
+	}
+	if peak == 0 {
+		peak = 1
+	}
+	resolution := peak / math.MaxInt16
+	s.gain = scale / resolution
+	for n, v := range ch.Samples {
+		if math.IsNaN(v) {
+			s.digital[n] = invalidSample
+			continue
+		}
+		s.digital[n] = int16(math.Round(v / resolution))
+	}
+	return s
+}
+
+// checksum は WFDB ヘッダに書く16bitのチェックサムを返す
+func (s signal) checksum() int16 {
+	var sum int16
+	for _, v := range s.digital {
+		sum += v
+	}
+	return sum
+}
+
+// fitsInt16 は値がすべて欠損値を除く16bit整数の範囲にある整数かどうかを返す
+func fitsInt16(values []float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		if v != math.Trunc(v) || v <= invalidSample || v > math.MaxInt16 {
+			return false
+		}
+	}
+	return true
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
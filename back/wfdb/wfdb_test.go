@@ -0,0 +1,57 @@
+package wfdb
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/shikidalab/anonymize-ecg/mfer"
+)
+
+func TestWrite(t *testing.T) {
+	// 2.5µV/LSB, 500Hz, 2誘導の波形
+	w := &mfer.Waveform{
+		SamplingRate: 500,
+		Channels: []mfer.Channel{
+			{Lead: "I", Resolution: 2.5, Raw: []float64{1, 2, 3}, Samples: []float64{2.5, 5, 7.5}},
+			{Lead: "II", Resolution: 2.5, Raw: []float64{-1, 0, 4}, Samples: []float64{-2.5, 0, math.NaN()}},
+		},
+	}
+
+	files, err := Write("abcd_20240101", w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Name != "abcd_20240101.hea" || files[1].Name != "abcd_20240101.dat" {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+
+	// 欠損値を含む II のチェックサムは -1 + 0 + -32768 を16bitで丸めた値になる
+	expectedHeader := "abcd_20240101 2 500 3\n" +
+		"abcd_20240101.dat 16 400(0)/mV 16 0 1 6 0 I\n" +
+		"abcd_20240101.dat 16 400(0)/mV 16 0 -1 32767 0 II\n"
+	if got := string(files[0].Content); got != expectedHeader {
+		t.Errorf("unexpected header\nexpected: %q\ngot: %q", expectedHeader, got)
+	}
+
+	// サンプルは時刻ごとにチャネル順で並ぶ
+	expectedData := []byte{
+		0x01, 0x00, 0xff, 0xff,
+		0x02, 0x00, 0x00, 0x00,
+		0x03, 0x00, 0x00, 0x80,
+	}
+	if !bytes.Equal(files[1].Content, expectedData) {
+		t.Errorf("expected: %v, got %v", expectedData, files[1].Content)
+	}
+}
+
+func TestWriteInvalidRecordName(t *testing.T) {
+	w := &mfer.Waveform{
+		SamplingRate: 500,
+		Channels:     []mfer.Channel{{Lead: "I", Resolution: 2.5, Raw: []float64{1}, Samples: []float64{2.5}}},
+	}
+	if _, err := Write("v1-abcd_20240101", w); !errors.Is(err, ErrInvalidRecordName) {
+		t.Errorf("expected ErrInvalidRecordName, got: %v", err)
+	}
+}
@@ -0,0 +1,370 @@
+package xml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	hl7Namespace = "urn:hl7-org:v3"
+	xsiNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+var ErrNoWaveform = errors.New("no waveform sequence in document")
+
+// ECG は HL7 aECG 文書に含まれるリズム波形と、匿名化後も残す患者情報を表す
+type ECG struct {
+	PatientID    string
+	Name         string // 患者名 (匿名化済みの文書では空)
+	Sex          string // administrativeGenderCode の code (M, F, UN)
+	BirthTime    string // birthTime の value
+	Start        string // 波形の開始時刻 (HL7 TS)
+	SamplingRate float64
+	Leads        []Lead
+}
+
+// AnnotatedECG は HL7 aECG 文書の波形部分を表す
+type AnnotatedECG struct {
+	Series []Series
+}
+
+// Series は1つの series (または derivedSeries) を表す
+type Series struct {
+	Code         string // RHYTHM, REPRESENTATIVE_BEAT など
+	Derived      bool   // derivation の中の derivedSeries かどうか
+	Start        string // 時刻の sequence の head (HL7 TS)
+	SamplingRate float64
+	Leads        []Lead
+}
+
+// Lead は1誘導分の波形を表す
+// 物理量(µV)は Origin + Scale * Digits で求める
+type Lead struct {
+	Code   string  // MDC_ECG_LEAD_I など
+	Origin float64 // µV
+	Scale  float64 // µV
+	Digits []int
+}
+
+// Samples は誘導の物理量(µV)を返す
+func (l Lead) Samples() []float64 {
+	samples := make([]float64, len(l.Digits))
+	for i, d := range l.Digits {
+		samples[i] = l.Origin + l.Scale*float64(d)
+	}
+	return samples
+}
+
+type valueXML struct {
+	Value string `xml:"value,attr"`
+	Unit  string `xml:"unit,attr"`
+}
+
+type sequenceXML struct {
+	Code struct {
+		Code string `xml:"code,attr"`
+	} `xml:"code"`
+	Value struct {
+		Head      valueXML `xml:"head"`
+		Increment valueXML `xml:"increment"`
+		Origin    valueXML `xml:"origin"`
+		Scale     valueXML `xml:"scale"`
+		Digits    string   `xml:"digits"`
+	} `xml:"value"`
+}
+
+type seriesXML struct {
+	Code struct {
+		Code string `xml:"code,attr"`
+	} `xml:"code"`
+	Sequences []sequenceXML `xml:"component>sequenceSet>component>sequence"`
+	Derived   []seriesXML   `xml:"derivation>derivedSeries"`
+}
+
+type documentXML struct {
+	Series []seriesXML `xml:"component>series"`
+}
+
+// ParseAnnotatedECG は HL7 aECG 文書のすべての series の波形を読む
+func ParseAnnotatedECG(xmlData []byte) (*AnnotatedECG, error) {
+	var doc documentXML
+	if err := newDecoder(xmlData).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("error decoding aECG: %w", err)
+	}
+
+	aecg := &AnnotatedECG{}
+	for _, sx := range doc.Series {
+		series, err := parseSeries(sx, false)
+		if err != nil {
+			return nil, err
+		}
+		aecg.Series = append(aecg.Series, series)
+
+		for _, dx := range sx.Derived {
+			derived, err := parseSeries(dx, true)
+			if err != nil {
+				return nil, err
+			}
+			aecg.Series = append(aecg.Series, derived)
+		}
+	}
+	if len(aecg.Series) == 0 {
+		return nil, ErrNoWaveform
+	}
+	return aecg, nil
+}
+
+func parseSeries(sx seriesXML, derived bool) (Series, error) {
+	series := Series{Code: sx.Code.Code, Derived: derived}
+	for _, seq := range sx.Sequences {
+		value := seq.Value
+		if strings.HasPrefix(seq.Code.Code, "TIME_") {
+			// 時刻の sequence は開始時刻とサンプリング間隔を持つ
+			interval, err := parseQuantity(value.Increment, "s")
+			if err != nil {
+				return Series{}, fmt.Errorf("error increment of %s: %w", seq.Code.Code, err)
+			}
+			if interval <= 0 {
+				return Series{}, fmt.Errorf("invalid increment %v", interval)
+			}
+			series.SamplingRate = 1 / interval
+			series.Start = value.Head.Value
+			continue
+		}
+
+		lead := Lead{Code: seq.Code.Code, Scale: 1}
+		var err error
+		if value.Origin.Value != "" {
+			if lead.Origin, err = parseQuantity(value.Origin, "uV"); err != nil {
+				return Series{}, fmt.Errorf("error origin of %s: %w", seq.Code.Code, err)
+			}
+		}
+		if value.Scale.Value != "" {
+			if lead.Scale, err = parseQuantity(value.Scale, "uV"); err != nil {
+				return Series{}, fmt.Errorf("error scale of %s: %w", seq.Code.Code, err)
+			}
+		}
+		for _, field := range strings.Fields(value.Digits) {
+			digit, err := strconv.Atoi(field)
+			if err != nil {
+				return Series{}, fmt.Errorf("error digits of %s: %w", seq.Code.Code, err)
+			}
+			lead.Digits = append(lead.Digits, digit)
+		}
+		series.Leads = append(series.Leads, lead)
+	}
+	return series, nil
+}
+
+// Rhythm はリズム波形の series を返す
+// RHYTHM の series がない場合は派生でない最初の series を返す
+func (a *AnnotatedECG) Rhythm() *Series {
+	var first *Series
+	for i := range a.Series {
+		s := &a.Series[i]
+		if s.Derived {
+			continue
+		}
+		if s.Code == "RHYTHM" {
+			return s
+		}
+		if first == nil {
+			first = s
+		}
+	}
+	return first
+}
+
+// Validate は series の波形が変換や比較に使える形になっているかを確かめる
+func (s *Series) Validate() error {
+	if s.SamplingRate <= 0 {
+		return fmt.Errorf("series %s: missing sampling rate", s.Code)
+	}
+	if len(s.Leads) == 0 {
+		return fmt.Errorf("series %s: %w", s.Code, ErrNoWaveform)
+	}
+	for _, lead := range s.Leads {
+		if lead.Scale == 0 {
+			return fmt.Errorf("series %s: lead %s has zero scale", s.Code, lead.Code)
+		}
+		if len(lead.Digits) != len(s.Leads[0].Digits) {
+			return fmt.Errorf("series %s: lead %s has %d samples, expected %d", s.Code, lead.Code, len(lead.Digits), len(s.Leads[0].Digits))
+		}
+	}
+	return nil
+}
+
+// ParseECG は HL7 aECG 文書のリズム波形と患者情報を読む
+func ParseECG(xmlData []byte) (*ECG, error) {
+	aecg, err := ParseAnnotatedECG(xmlData)
+	if err != nil {
+		return nil, err
+	}
+	rhythm := aecg.Rhythm()
+	if rhythm == nil {
+		return nil, ErrNoWaveform
+	}
+	if err := rhythm.Validate(); err != nil {
+		return nil, err
+	}
+
+	ecg := &ECG{
+		Start:        rhythm.Start,
+		SamplingRate: rhythm.SamplingRate,
+		Leads:        rhythm.Leads,
+	}
+
+	// 匿名化済みの文書は患者IDが空になっている
+	info, err := GetPersonalInfo(xmlData)
+	if err != nil && !errors.Is(err, ErrNoPatientID) {
+		return nil, err
+	}
+	ecg.PatientID = info.PatientID
+	ecg.Name = info.Name
+	ecg.BirthTime = info.BirthTime
+	ecg.Sex = info.Sex
+
+	return ecg, nil
+}
+
+// parseQuantity は単位付きの値を want の単位に換算する
+func parseQuantity(v valueXML, want string) (float64, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(v.Value), 64)
+	if err != nil {
+		return 0, err
+	}
+	factors := map[string]float64{
+		"s": 1, "ms": 1e-3, "us": 1e-6,
+		"V": 1e6, "mV": 1e3, "uV": 1, "nV": 1e-3,
+	}
+	unit := v.Unit
+	if unit == "" {
+		unit = want
+	}
+	from, ok := factors[unit]
+	if !ok {
+		return 0, fmt.Errorf("unsupported unit %q", v.Unit)
+	}
+	return value * from / factors[want], nil
+}
+
+// Marshal は波形と患者情報を HL7 aECG 文書として書く
+func (e *ECG) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+
+	w := &elementWriter{encoder: encoder}
+	w.start("AnnotatedECG", "xmlns", hl7Namespace, "xmlns:xsi", xsiNamespace)
+	w.empty("code", "code", "93000", "codeSystem", "2.16.840.1.113883.6.12")
+	if e.Start != "" {
+		w.start("effectiveTime")
+		w.empty("low", "value", e.Start)
+		w.end("effectiveTime")
+	}
+
+	// 患者情報
+	w.start("subject")
+	w.start("patient")
+	w.start("patientPatient")
+	w.empty("id", "extension", e.PatientID)
+	if e.Sex != "" {
+		w.empty("administrativeGenderCode", "code", e.Sex, "codeSystem", "2.16.840.1.113883.5.1")
+	}
+	if e.BirthTime != "" {
+		w.empty("birthTime", "value", e.BirthTime)
+	}
+	w.end("patientPatient")
+	w.end("patient")
+	w.end("subject")
+
+	// 波形
+	w.start("component")
+	w.start("series")
+	w.empty("code", "code", "RHYTHM", "codeSystem", "2.16.840.1.113883.5.4")
+	w.start("component")
+	w.start("sequenceSet")
+
+	w.start("component")
+	w.start("sequence")
+	w.empty("code", "code", "TIME_ABSOLUTE", "codeSystem", "2.16.840.1.113883.5.4")
+	w.start("value", "xsi:type", "GLIST_TS")
+	w.empty("head", "value", e.Start, "unit", "s")
+	w.empty("increment", "value", strconv.FormatFloat(1/e.SamplingRate, 'f', -1, 64), "unit", "s")
+	w.end("value")
+	w.end("sequence")
+	w.end("component")
+
+	for _, lead := range e.Leads {
+		digits := make([]string, len(lead.Digits))
+		for i, d := range lead.Digits {
+			digits[i] = strconv.Itoa(d)
+		}
+
+		w.start("component")
+		w.start("sequence")
+		w.empty("code", "code", lead.Code, "codeSystem", "2.16.840.1.113883.6.24")
+		w.start("value", "xsi:type", "SLIST_PQ")
+		w.empty("origin", "value", strconv.FormatFloat(lead.Origin, 'f', -1, 64), "unit", "uV")
+		w.empty("scale", "value", strconv.FormatFloat(lead.Scale, 'f', -1, 64), "unit", "uV")
+		w.text("digits", strings.Join(digits, " "))
+		w.end("value")
+		w.end("sequence")
+		w.end("component")
+	}
+
+	w.end("sequenceSet")
+	w.end("component")
+	w.end("series")
+	w.end("component")
+	w.end("AnnotatedECG")
+
+	if w.err != nil {
+		return nil, fmt.Errorf("error encoding aECG: %w", w.err)
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, fmt.Errorf("error flushing encoder: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// elementWriter は最初のエラーを保持しながら要素を書く
+type elementWriter struct {
+	encoder *xml.Encoder
+	err     error
+}
+
+func (w *elementWriter) token(t xml.Token) {
+	if w.err == nil {
+		w.err = w.encoder.EncodeToken(t)
+	}
+}
+
+// start は開始タグを書く (attrs は名前と値を交互に並べる)
+func (w *elementWriter) start(local string, attrs ...string) {
+	tok := xml.StartElement{Name: xml.Name{Local: local}}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		tok.Attr = append(tok.Attr, xml.Attr{Name: xml.Name{Local: attrs[i]}, Value: attrs[i+1]})
+	}
+	w.token(tok)
+}
+
+func (w *elementWriter) end(local string) {
+	w.token(xml.EndElement{Name: xml.Name{Local: local}})
+}
+
+func (w *elementWriter) empty(local string, attrs ...string) {
+	w.start(local, attrs...)
+	w.end(local)
+}
+
+func (w *elementWriter) text(local, text string) {
+	w.start(local)
+	w.token(xml.CharData(text))
+	w.end(local)
+}
@@ -0,0 +1,130 @@
+package xml
+
+import (
+	"errors"
+	"testing"
+)
+
+var testAECG = []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<AnnotatedECG xmlns="urn:hl7-org:v3">
+  <component>
+    <series>
+      <code code="RHYTHM"/>
+      <component>
+        <sequenceSet>
+          <component>
+            <sequence>
+              <code code="TIME_ABSOLUTE"/>
+              <value>
+                <head value="20070301102030.000"/>
+                <increment value="0.002" unit="s"/>
+              </value>
+            </sequence>
+          </component>
+          <component>
+            <sequence>
+              <code code="MDC_ECG_LEAD_I"/>
+              <value>
+                <origin value="0.01" unit="mV"/>
+                <scale value="2.5" unit="uV"/>
+                <digits>0 4
+                  -4</digits>
+              </value>
+            </sequence>
+          </component>
+          <component>
+            <sequence>
+              <code code="MDC_ECG_LEAD_II"/>
+              <value>
+                <origin value="0" unit="uV"/>
+                <scale value="5" unit="uV"/>
+                <digits>1 2 3</digits>
+              </value>
+            </sequence>
+          </component>
+        </sequenceSet>
+      </component>
+      <derivation>
+        <derivedSeries>
+          <code code="REPRESENTATIVE_BEAT"/>
+          <component>
+            <sequenceSet>
+              <component>
+                <sequence>
+                  <code code="TIME_RELATIVE"/>
+                  <value>
+                    <head value="0" unit="s"/>
+                    <increment value="1" unit="ms"/>
+                  </value>
+                </sequence>
+              </component>
+              <component>
+                <sequence>
+                  <code code="MDC_ECG_LEAD_I"/>
+                  <value>
+                    <origin value="0" unit="uV"/>
+                    <scale value="1" unit="uV"/>
+                    <digits>7 8</digits>
+                  </value>
+                </sequence>
+              </component>
+            </sequenceSet>
+          </component>
+        </derivedSeries>
+      </derivation>
+    </series>
+  </component>
+</AnnotatedECG>`)
+
+func TestParseAnnotatedECG(t *testing.T) {
+	aecg, err := ParseAnnotatedECG(testAECG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aecg.Series) != 2 {
+		t.Fatalf("unexpected series count, got: %d, want: %d", len(aecg.Series), 2)
+	}
+
+	rhythm := aecg.Rhythm()
+	if rhythm.Code != "RHYTHM" || rhythm.Derived || rhythm.SamplingRate != 500 || rhythm.Start != "20070301102030.000" {
+		t.Errorf("unexpected rhythm series: %+v", rhythm)
+	}
+	if err := rhythm.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+	expected := []float64{10, 20, 0}
+	samples := rhythm.Leads[0].Samples()
+	for i, want := range expected {
+		if samples[i] != want {
+			t.Errorf("unexpected sample %d, got: %v, want: %v", i, samples[i], want)
+		}
+	}
+
+	beat := aecg.Series[1]
+	if beat.Code != "REPRESENTATIVE_BEAT" || !beat.Derived || beat.SamplingRate != 1000 || len(beat.Leads) != 1 {
+		t.Errorf("unexpected derived series: %+v", beat)
+	}
+}
+
+func TestSeriesValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		series Series
+	}{
+		{"no sampling rate", Series{Leads: []Lead{{Scale: 1, Digits: []int{1}}}}},
+		{"no leads", Series{SamplingRate: 500}},
+		{"zero scale", Series{SamplingRate: 500, Leads: []Lead{{Digits: []int{1}}}}},
+		{"length mismatch", Series{SamplingRate: 500, Leads: []Lead{{Scale: 1, Digits: []int{1, 2}}, {Scale: 1, Digits: []int{1}}}}},
+	}
+	for _, tt := range tests {
+		if err := tt.series.Validate(); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestParseAnnotatedECGNoWaveform(t *testing.T) {
+	if _, err := ParseAnnotatedECG([]byte(`<AnnotatedECG/>`)); !errors.Is(err, ErrNoWaveform) {
+		t.Errorf("expected ErrNoWaveform, got: %v", err)
+	}
+}
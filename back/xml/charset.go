@@ -0,0 +1,105 @@
+package xml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// declaredEncoding は XML 宣言の encoding
+var declaredEncoding = regexp.MustCompile(`^\s*<\?xml[^>]*\sencoding\s*=\s*["']([^"']+)["']`)
+
+// lookupEncoding は文字コード名から変換方法を返す (UTF-8 と ASCII の場合は nil)
+func lookupEncoding(label string) (encoding.Encoding, error) {
+	switch strings.ToLower(label) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return nil, nil
+	}
+	enc, err := ianaindex.IANA.Encoding(label)
+	if err != nil || enc == nil {
+		return nil, fmt.Errorf("unsupported encoding %q", label)
+	}
+	return enc, nil
+}
+
+// newDecoder は XML 宣言の文字コードを UTF-8 に変換しながら読むデコーダを返す
+// GE MUSE の XML は ISO-8859-1 で書かれている
+func newDecoder(xmlData []byte) *xml.Decoder {
+	decoder := xml.NewDecoder(bytes.NewReader(xmlData))
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		enc, err := lookupEncoding(label)
+		if err != nil || enc == nil {
+			return input, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+	return decoder
+}
+
+// toUTF8 は文書を UTF-8 に変換し、元の文字コードを返す
+// 書き換えの位置を UTF-8 のバイト列で数えるために使う
+func toUTF8(xmlData []byte) ([]byte, encoding.Encoding, error) {
+	m := declaredEncoding.FindSubmatch(xmlData)
+	if m == nil {
+		return xmlData, nil, nil
+	}
+	enc, err := lookupEncoding(string(m[1]))
+	if err != nil || enc == nil {
+		return xmlData, nil, err
+	}
+	converted, err := enc.NewDecoder().Bytes(xmlData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding %s: %w", m[1], err)
+	}
+	return converted, enc, nil
+}
+
+// fromUTF8 は toUTF8 で変換した文書を元の文字コードに戻す
+// 元の文字コードで表せない文字は置き換える
+func fromUTF8(xmlData []byte, enc encoding.Encoding) ([]byte, error) {
+	if enc == nil {
+		return xmlData, nil
+	}
+	converted, err := encoding.ReplaceUnsupported(enc.NewEncoder()).Bytes(xmlData)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding document: %w", err)
+	}
+	return converted, nil
+}
+
+// WalkText は文書中のテキストと属性値を、要素の経路と文書中の位置とともに順に fn に渡す
+// 文字コードが UTF-8 でない文書の位置は UTF-8 に変換した後の位置になる
+func WalkText(xmlData []byte, fn func(offset int64, path []string, text string)) error {
+	decoder := newDecoder(xmlData)
+	var path []string
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error decoding token: %w", err)
+		}
+
+		switch tok := token.(type) {
+		case xml.StartElement:
+			path = append(path, tok.Name.Local)
+			for _, attr := range tok.Attr {
+				fn(offset, path, attr.Value)
+			}
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		case xml.CharData:
+			fn(offset, path, string(tok))
+		case xml.Comment:
+			fn(offset, path, string(tok))
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package xml
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// tsLayout は HL7 TS 形式の日時の数字部分のレイアウト
+const tsLayout = "20060102150405"
+
+// dateLayouts は HL7 TS 形式以外で機器のXMLに使われる日付の形式
+// GE MUSE は MM-DD-YYYY、Philips は YYYY-MM-DD を使う
+var dateLayouts = []string{"2006-01-02", "01-02-2006", "2006/01/02"}
+
+// tsSuffix は HL7 TS 形式の数字部分に続く小数部とタイムゾーン
+var tsSuffix = regexp.MustCompile(`^(\.\d+)?([+-]\d{4})?$`)
+
+// parseTS は HL7 TS 形式 (YYYY[MM[DD[HH[MM[SS]]]]][.UUUU][+ZZZZ]) の日時を読む
+// 数字部分の桁数と、それに続く小数部やタイムゾーンの文字列も返す
+func parseTS(value string) (time.Time, int, string, error) {
+	digits := 0
+	for digits < len(value) && value[digits] >= '0' && value[digits] <= '9' {
+		digits++
+	}
+	if digits < 4 || digits > len(tsLayout) || digits%2 != 0 {
+		return time.Time{}, 0, "", fmt.Errorf("invalid HL7 TS %q", value)
+	}
+	rest := value[digits:]
+	if !tsSuffix.MatchString(rest) {
+		return time.Time{}, 0, "", fmt.Errorf("invalid HL7 TS %q", value)
+	}
+	t, err := time.Parse(tsLayout[:digits], value[:digits])
+	if err != nil {
+		return time.Time{}, 0, "", fmt.Errorf("invalid HL7 TS %q: %w", value, err)
+	}
+	return t, digits, rest, nil
+}
+
+// generalizeDate は日付を年と月だけにする (年しかない場合は年のみ)
+// HL7 TS 形式の値は HL7 TS 形式の YYYYMM で、機器のXMLの日付は YYYY/M で返す
+// 読めない値は識別につながる可能性があるため空にする
+func generalizeDate(value string) string {
+	t, digits, _, err := parseTS(value)
+	if err != nil {
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return fmt.Sprintf("%d/%d", t.Year(), t.Month())
+			}
+		}
+		return ""
+	}
+	if digits == 4 {
+		return value[:4]
+	}
+	return t.Format("200601")
+}
+
+// shiftDate は日付を days 日ずらす
+// 日より粗い精度の値はずらせないのでそのまま返す
+// low や high は数量(IVL_PQ)にも使われるため、日時として読めない値もそのまま返す
+func shiftDate(value string, days int) string {
+	if days == 0 || value == "" {
+		return value
+	}
+	t, digits, rest, err := parseTS(value)
+	if err != nil {
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t.AddDate(0, 0, days).Format(layout)
+			}
+		}
+		return value
+	}
+	if digits < 8 {
+		return value
+	}
+	return t.AddDate(0, 0, days).Format(tsLayout[:digits]) + rest
+}
+
+// ParseDate は日まで分かる日付を読む
+// HL7 TS 形式と機器のXMLの日付の形式に対応する
+func ParseDate(value string) (time.Time, error) {
+	if t, digits, _, err := parseTS(value); err == nil && digits >= 8 {
+		return t, nil
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+// hashValue は鍵付きハッシュ(HMAC-SHA256)の先頭16バイトを16進数で返す
+func hashValue(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
@@ -0,0 +1,139 @@
+package xml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Dialect は心電図XMLの形式
+type Dialect string
+
+const (
+	DialectUnknown Dialect = ""
+	// DialectHL7 は HL7 aECG (ルート要素は AnnotatedECG)
+	DialectHL7 Dialect = "hl7-aecg"
+	// DialectNihonKohden は日本光電の心電計が書き出す HL7 aECG
+	// 構造は HL7 aECG と同じで、製造元の名前で見分ける
+	DialectNihonKohden Dialect = "nihon-kohden"
+	// DialectMUSE は GE MUSE の RestingECG
+	DialectMUSE Dialect = "ge-muse"
+	// DialectPhilips は Philips の restingecgdata
+	DialectPhilips Dialect = "philips"
+)
+
+// DetectDialect はルート要素の名前から形式を判定する
+// HL7 aECG の場合は manufacturerOrganization の名前も見て日本光電の文書かどうかを判定する
+func DetectDialect(xmlData []byte) (Dialect, error) {
+	decoder := newDecoder(xmlData)
+	var path []string
+	dialect := DialectUnknown
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return dialect, nil
+		}
+		if err != nil {
+			return DialectUnknown, fmt.Errorf("error decoding token: %w", err)
+		}
+
+		switch tok := token.(type) {
+		case xml.StartElement:
+			path = append(path, tok.Name.Local)
+			if len(path) > 1 {
+				continue
+			}
+			switch tok.Name.Local {
+			case "AnnotatedECG":
+				dialect = DialectHL7
+			case "RestingECG":
+				return DialectMUSE, nil
+			case "restingecgdata":
+				return DialectPhilips, nil
+			default:
+				return DialectUnknown, nil
+			}
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		case xml.CharData:
+			n := len(path)
+			if n >= 2 && path[n-2] == "manufacturerOrganization" && path[n-1] == "name" &&
+				strings.Contains(strings.ToUpper(string(tok)), "NIHON KOHDEN") {
+				return DialectNihonKohden, nil
+			}
+		}
+	}
+}
+
+// isHL7 は HL7 aECG の要素の名前で書かれた形式かどうかを返す
+// 形式が分からない場合も HL7 aECG として扱う
+func (d Dialect) isHL7() bool {
+	return d != DialectMUSE && d != DialectPhilips
+}
+
+// Policy は形式ごとの既定の匿名化の規則を返す
+// 形式が分からない場合は HL7 aECG の規則を使う
+func (d Dialect) Policy() *Policy {
+	switch d {
+	case DialectMUSE:
+		return musePolicy()
+	case DialectPhilips:
+		return philipsPolicy()
+	default:
+		return DefaultPolicy()
+	}
+}
+
+func musePolicy() *Policy {
+	rules := []Rule{
+		{Path: "PatientDemographics/PatientID", Action: ActionBlank},
+		{Path: "PatientDemographics/DateofBirth", Action: ActionGeneralizeDate},
+		{Path: "TestDemographics/AcquisitionDate", Action: ActionShiftDate},
+		{Path: "TestDemographics/EditDate", Action: ActionShiftDate},
+	}
+	for _, path := range []string{
+		"PatientDemographics/PatientLastName",
+		"PatientDemographics/PatientFirstName",
+		"PatientDemographics/PatientMiddleName",
+		"PatientDemographics/HISAccountNumber",
+		"TestDemographics/LocationName",
+		"TestDemographics/SiteName",
+		"TestDemographics/RoomID",
+		"TestDemographics/AcquisitionTechID",
+		"TestDemographics/AcquisitionTechLastName",
+		"TestDemographics/AcquisitionTechFirstName",
+		"TestDemographics/OrderingMDID",
+		"TestDemographics/OrderingMDLastName",
+		"TestDemographics/OrderingMDFirstName",
+		"TestDemographics/ReferringMDLastName",
+		"TestDemographics/ReferringMDFirstName",
+		"TestDemographics/OverreaderID",
+		"TestDemographics/OverreaderLastName",
+		"TestDemographics/OverreaderFirstName",
+		"TestDemographics/EditorID",
+		"TestDemographics/EditorLastName",
+		"TestDemographics/EditorFirstName",
+	} {
+		rules = append(rules, Rule{Path: path, Action: ActionBlank})
+	}
+	return &Policy{Rules: rules}
+}
+
+func philipsPolicy() *Policy {
+	return &Policy{Rules: []Rule{
+		{Path: "generalpatientdata/patientid", Action: ActionBlank},
+		{Path: "generalpatientdata/MRN", Action: ActionBlank},
+		{Path: "generalpatientdata/name/lastname", Action: ActionBlank},
+		{Path: "generalpatientdata/name/firstname", Action: ActionBlank},
+		{Path: "generalpatientdata/name/middlename", Action: ActionBlank},
+		{Path: "generalpatientdata/age/dateofbirth", Action: ActionGeneralizeDate},
+		{Path: "/restingecgdata/dataacquisition", Attribute: "date", Action: ActionShiftDate},
+		{Path: "acquirer/operatorid", Action: ActionBlank},
+		{Path: "acquirer/institutionname", Action: ActionBlank},
+		{Path: "acquirer/institutionlocationname", Action: ActionBlank},
+		{Path: "orderinfo/ordernumber", Action: ActionBlank},
+		{Path: "orderinfo/referringphysician", Action: ActionBlank},
+	}}
+}
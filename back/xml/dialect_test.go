@@ -0,0 +1,106 @@
+package xml
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDialects(t *testing.T) {
+	tests := []struct {
+		file        string
+		dialect     Dialect
+		ecgID       string
+		patientID   string
+		name        string
+		givenName   string
+		birthtime   string
+		sex         string
+		acquisition string
+		device      string
+		identifier  []string // 匿名化後に残ってはいけない値
+		generalize  string   // 匿名化後の生年月
+	}{
+		{"hl7.xml", DialectHL7, "ECG-0001", "PID-1001", "Yamada", "Taro", "19841123000000", "M", "20070301102030", "CARDIO-1", []string{"ECG-0001", "PID-1001", "Yamada", "Taro"}, `value="198411"`},
+		{"nihonkohden.xml", DialectNihonKohden, "ECG-0002", "PID-1002", "Yamada", "Taro", "19841123000000", "M", "20070301102030", "ECG-2550", []string{"ECG-0002", "PID-1002", "Yamada", "Taro"}, `value="198411"`},
+		{"muse.xml", DialectMUSE, "", "PID-1003", "YAMADA", "TARO", "11-23-1984", "MALE", "03-01-2007 10:20:30", "MAC55", []string{"PID-1003", "YAMADA", "TARO", "SUZUKI", "HANAKO", "Kobe"}, "1984/11"},
+		{"philips.xml", DialectPhilips, "", "PID-1004", "Yamada", "Taro", "1984-11-23", "Male", "2007-03-01 10:20:30", "PageWriter Trim III", []string{"PID-1004", "Yamada", "Taro", "OP-7", "Kobe"}, "1984/11"},
+	}
+
+	// XML_POLICY の規則は HL7 aECG の要素の名前で書かれているので、MUSE や Philips には使わない
+	custom, err := LoadPolicy(filepath.Join("..", "..", "setup", "xml-policy.sample.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		xmlData, err := os.ReadFile(filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dialect, err := DetectDialect(xmlData)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.file, err)
+		}
+		if dialect != tt.dialect {
+			t.Errorf("%s: unexpected dialect, got: %s, want: %s", tt.file, dialect, tt.dialect)
+		}
+
+		info, err := GetPersonalInfo(xmlData)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.file, err)
+		}
+		if info.Dialect != tt.dialect || info.ECGID != tt.ecgID || info.PatientID != tt.patientID || info.Name != tt.name || info.GivenName != tt.givenName || info.BirthTime != tt.birthtime {
+			t.Errorf("%s: unexpected personal info: %+v", tt.file, info)
+		}
+		if info.Sex != tt.sex || info.AcquisitionTime != tt.acquisition || info.Device != tt.device {
+			t.Errorf("%s: unexpected recording info: %+v", tt.file, info)
+		}
+
+		for _, policy := range []*Policy{nil, custom} {
+			anonymized, err := AnonymizeWithOptions(xmlData, Options{PreserveFormat: true, Policy: policy})
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", tt.file, err)
+			}
+			for _, s := range tt.identifier {
+				if strings.Contains(string(anonymized), s) {
+					t.Errorf("%s (custom policy: %t): %q remains in output", tt.file, policy != nil, s)
+				}
+			}
+			if !strings.Contains(string(anonymized), tt.generalize) {
+				t.Errorf("%s (custom policy: %t): birth date is not generalized: %s", tt.file, policy != nil, anonymized)
+			}
+		}
+	}
+}
+
+func TestAnonymizeKeepsEncoding(t *testing.T) {
+	xmlData, err := os.ReadFile(filepath.Join("testdata", "muse.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymized, err := AnonymizeWithOptions(xmlData, Options{PreserveFormat: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ISO-8859-1 の文書は ISO-8859-1 のまま書く
+	if !bytes.Contains(anonymized, []byte("100 \xb5V")) {
+		t.Errorf("ISO-8859-1 text is not preserved: %q", anonymized)
+	}
+	if !bytes.HasPrefix(anonymized, []byte(`<?xml version="1.0" encoding="ISO-8859-1"?>`)) {
+		t.Errorf("XML declaration is not preserved: %q", anonymized)
+	}
+}
+
+func TestDetectDialectUnknown(t *testing.T) {
+	dialect, err := DetectDialect([]byte(`<ecg><patient/></ecg>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dialect != DialectUnknown {
+		t.Errorf("unexpected dialect: %s", dialect)
+	}
+}
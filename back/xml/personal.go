@@ -0,0 +1,222 @@
+package xml
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ErrNoPatientID = errors.New("patient ID not found")
+
+// Field は PersonalInfo の項目
+type Field string
+
+const (
+	FieldECGID           Field = "ecgID"
+	FieldPatientID       Field = "patientID"
+	FieldName            Field = "name"
+	FieldGivenName       Field = "givenName"
+	FieldBirthTime       Field = "birthTime"
+	FieldSex             Field = "sex"
+	FieldAcquisitionTime Field = "acquisitionTime"
+	FieldDevice          Field = "device"
+)
+
+var fields = []Field{FieldECGID, FieldPatientID, FieldName, FieldGivenName, FieldBirthTime, FieldSex, FieldAcquisitionTime, FieldDevice}
+
+// PersonalInfo は匿名化の前に XML から読む患者と記録の情報
+// 値は文書に書かれた形式のまま返す
+type PersonalInfo struct {
+	Dialect         Dialect
+	ECGID           string
+	PatientID       string
+	Name            string // 姓
+	GivenName       string // 名
+	BirthTime       string
+	Sex             string
+	AcquisitionTime string
+	Device          string
+
+	found map[Field]bool
+}
+
+// Found は項目が文書にあったかどうかを返す (値が空の場合も true を返す)
+func (p *PersonalInfo) Found(field Field) bool {
+	return p.found[field]
+}
+
+func (p *PersonalInfo) set(field Field, value string) {
+	switch field {
+	case FieldECGID:
+		p.ECGID = value
+	case FieldPatientID:
+		p.PatientID = value
+	case FieldName:
+		p.Name = value
+	case FieldGivenName:
+		p.GivenName = value
+	case FieldBirthTime:
+		p.BirthTime = value
+	case FieldSex:
+		p.Sex = value
+	case FieldAcquisitionTime:
+		p.AcquisitionTime = value
+	case FieldDevice:
+		p.Device = value
+	}
+	if p.found == nil {
+		p.found = make(map[Field]bool)
+	}
+	p.found[field] = true
+}
+
+// fieldPaths は形式ごとの各項目の経路の候補
+// 経路の書き方は Rule.Path と同じで、"@" の後は属性名
+// 1つの候補に複数の経路がある場合は、すべて見つかったときに空白でつないだ値を使う
+type fieldPaths map[Field][][]string
+
+var hl7Fields = fieldPaths{
+	FieldECGID:     {{"/AnnotatedECG/id@extension"}},
+	FieldPatientID: {{"patientPatient/id@extension"}, {"trialSubject/id@extension"}},
+	FieldName: {
+		{"patientPatient/name/family"},
+		{"patientPatient/family"},
+		{"subjectDemographicPerson/name/family"},
+	},
+	FieldGivenName: {
+		{"patientPatient/name/given"},
+		{"patientPatient/given"},
+		{"subjectDemographicPerson/name/given"},
+	},
+	FieldBirthTime:       {{"patientPatient/birthTime@value"}, {"subjectDemographicPerson/birthTime@value"}},
+	FieldSex:             {{"patientPatient/administrativeGenderCode@code"}, {"subjectDemographicPerson/administrativeGenderCode@code"}},
+	FieldAcquisitionTime: {{"/AnnotatedECG/effectiveTime/low@value"}},
+	FieldDevice:          {{"manufacturedSeriesDevice/manufacturerModelName"}},
+}
+
+var fieldsOf = map[Dialect]fieldPaths{
+	DialectUnknown:     hl7Fields,
+	DialectHL7:         hl7Fields,
+	DialectNihonKohden: hl7Fields,
+	DialectMUSE: {
+		FieldPatientID:       {{"PatientDemographics/PatientID"}},
+		FieldName:            {{"PatientDemographics/PatientLastName"}},
+		FieldGivenName:       {{"PatientDemographics/PatientFirstName"}},
+		FieldBirthTime:       {{"PatientDemographics/DateofBirth"}},
+		FieldSex:             {{"PatientDemographics/Gender"}},
+		FieldAcquisitionTime: {{"TestDemographics/AcquisitionDate", "TestDemographics/AcquisitionTime"}},
+		FieldDevice:          {{"TestDemographics/AcquisitionDevice"}},
+	},
+	DialectPhilips: {
+		FieldPatientID:       {{"generalpatientdata/patientid"}},
+		FieldName:            {{"generalpatientdata/name/lastname"}},
+		FieldGivenName:       {{"generalpatientdata/name/firstname"}},
+		FieldBirthTime:       {{"generalpatientdata/age/dateofbirth"}},
+		FieldSex:             {{"generalpatientdata/sex"}},
+		FieldAcquisitionTime: {{"/restingecgdata/dataacquisition@date", "/restingecgdata/dataacquisition@time"}},
+		FieldDevice:          {{"dataacquisition/machine@detaildescription"}},
+	},
+}
+
+// GetPersonalInfo は文書の形式を判定して患者と記録の情報を読む
+// 患者IDがない、または空の場合は読めた情報とともに ErrNoPatientID を返す
+func GetPersonalInfo(xmlData []byte) (*PersonalInfo, error) {
+	dialect, err := DetectDialect(xmlData)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := fieldsOf[dialect]
+	var all []string
+	for _, field := range fields {
+		for _, candidate := range paths[field] {
+			all = append(all, candidate...)
+		}
+	}
+	values, found, err := extractFields(xmlData, all)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &PersonalInfo{Dialect: dialect}
+	i := 0
+	for _, field := range fields {
+		ok := false
+		for _, candidate := range paths[field] {
+			parts := values[i : i+len(candidate)]
+			all := !ok
+			for n := range candidate {
+				all = all && found[i+n]
+			}
+			if all {
+				info.set(field, strings.Join(parts, " "))
+				ok = true
+			}
+			i += len(candidate)
+		}
+	}
+
+	if info.PatientID == "" {
+		return info, ErrNoPatientID
+	}
+	return info, nil
+}
+
+// extractFields は経路ごとに当てはまる最初の値と、見つかったかどうかを返す
+func extractFields(xmlData []byte, paths []string) ([]string, []bool, error) {
+	values := make([]string, len(paths))
+	found := make([]bool, len(paths))
+	rules := make([]Rule, len(paths))
+	for i, path := range paths {
+		rules[i].Path, rules[i].Attribute, _ = strings.Cut(path, "@")
+	}
+
+	decoder := newDecoder(xmlData)
+	var path []string
+	// open[i] は経路 i に当てはまる要素のテキストを読んでいる途中かどうか
+	open := make([]bool, len(paths))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return values, found, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error decoding token: %w", err)
+		}
+
+		switch tok := token.(type) {
+		case xml.StartElement:
+			path = append(path, tok.Name.Local)
+			for i, rule := range rules {
+				if found[i] || open[i] || !rule.matches(path) {
+					continue
+				}
+				if rule.Attribute == "" {
+					open[i] = true
+					continue
+				}
+				for _, attr := range tok.Attr {
+					if attr.Name.Local == rule.Attribute {
+						values[i], found[i] = attr.Value, true
+					}
+				}
+			}
+		case xml.EndElement:
+			for i, rule := range rules {
+				if open[i] && rule.matches(path) {
+					values[i] = strings.TrimSpace(values[i])
+					open[i], found[i] = false, true
+				}
+			}
+			path = path[:len(path)-1]
+		case xml.CharData:
+			for i, rule := range rules {
+				if open[i] && rule.matches(path) {
+					values[i] += string(tok)
+				}
+			}
+		}
+	}
+}
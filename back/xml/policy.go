@@ -0,0 +1,156 @@
+package xml
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Action は規則に当てはまった値の扱い
+type Action string
+
+const (
+	ActionKeep           Action = "keep"            // 値をそのまま残す (後に書かれた規則を当てはめないために使う)
+	ActionRemove         Action = "remove"          // 要素または属性を取り除く
+	ActionBlank          Action = "blank"           // 値を空にする
+	ActionHash           Action = "hash"            // 値を鍵付きハッシュに置き換える
+	ActionGeneralizeDate Action = "generalize_date" // 日付を年月までにする
+	ActionShiftDate      Action = "shift_date"      // 日付を Options.DateShiftDays だけずらす
+	ActionConstant       Action = "constant"        // 値を Rule.Value に置き換える
+)
+
+var (
+	ErrInvalidPolicy = errors.New("invalid policy")
+	ErrNoHashKey     = errors.New("hash action requires a key")
+)
+
+// Rule は1つの匿名化の規則
+//
+// Path は要素のローカル名を / でつないだもので、"patientPatient/id" のように書くと
+// 要素の経路の末尾が一致する要素に当てはまる。"/AnnotatedECG/id" のように / で始めるとルート要素からの経路全体と比べる。
+// "*" は任意の1要素に当てはまる。
+// Attribute を指定した場合は属性の値に、指定しない場合は要素の直下のテキストに Action を適用する。
+type Rule struct {
+	Path      string `json:"path" yaml:"path"`
+	Attribute string `json:"attribute,omitempty" yaml:"attribute,omitempty"`
+	Action    Action `json:"action" yaml:"action"`
+	Value     string `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+// Policy は匿名化の規則の集まり
+// 同じ要素や属性に複数の規則が当てはまる場合は先に書かれた規則を使う
+type Policy struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// DefaultPolicy は設定がない場合に使う規則を返す
+func DefaultPolicy() *Policy {
+	return &Policy{Rules: []Rule{
+		{Path: "patientPatient/id", Attribute: "extension", Action: ActionBlank},
+		{Path: "/*/id", Attribute: "extension", Action: ActionBlank}, // 心電図のID
+		{Path: "manufacturerOrganization/name", Action: ActionKeep},  // 機器の製造元
+		{Path: "trialSubject/id", Attribute: "extension", Action: ActionBlank},
+		{Path: "assignedEntity/id", Attribute: "extension", Action: ActionBlank}, // 医師や技師のID
+		// 患者、医師、技師、施設などの名前
+		{Path: "name", Action: ActionBlank},
+		{Path: "family", Action: ActionBlank},
+		{Path: "given", Action: ActionBlank},
+		{Path: "prefix", Action: ActionBlank},
+		{Path: "suffix", Action: ActionBlank},
+		// 住所と電話番号・メールアドレス
+		{Path: "addr", Action: ActionRemove},
+		{Path: "telecom", Action: ActionRemove},
+		{Path: "birthTime", Attribute: "value", Action: ActionGeneralizeDate},
+		// 記録の日時は患者ごとに同じ日数だけずらし、記録間の間隔を保つ
+		{Path: "effectiveTime", Attribute: "value", Action: ActionShiftDate},
+		{Path: "effectiveTime/low", Attribute: "value", Action: ActionShiftDate},
+		{Path: "effectiveTime/high", Attribute: "value", Action: ActionShiftDate},
+		{Path: "effectiveTime/center", Attribute: "value", Action: ActionShiftDate},
+		{Path: "time", Attribute: "value", Action: ActionShiftDate},
+		{Path: "time/low", Attribute: "value", Action: ActionShiftDate},
+		{Path: "time/high", Attribute: "value", Action: ActionShiftDate},
+		{Path: "activityTime", Attribute: "value", Action: ActionShiftDate},
+		{Path: "sequence/value/head", Attribute: "value", Action: ActionShiftDate}, // TIME_ABSOLUTE の開始時刻
+	}}
+}
+
+// LoadPolicy は YAML または JSON で書かれた規則のファイルを読む
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading policy: %w", err)
+	}
+	return ParsePolicy(data)
+}
+
+// ParsePolicy は YAML または JSON で書かれた規則を読む (JSON は YAML として読める)
+func ParsePolicy(data []byte) (*Policy, error) {
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// Validate は規則の経路と Action を確かめる
+func (p *Policy) Validate() error {
+	for i, rule := range p.Rules {
+		if strings.Trim(rule.Path, "/") == "" {
+			return fmt.Errorf("%w: rule %d has no path", ErrInvalidPolicy, i)
+		}
+		switch rule.Action {
+		case ActionKeep, ActionRemove, ActionBlank, ActionHash, ActionGeneralizeDate, ActionShiftDate, ActionConstant:
+		default:
+			return fmt.Errorf("%w: rule %d has unknown action %q", ErrInvalidPolicy, i, rule.Action)
+		}
+	}
+	return nil
+}
+
+// match は経路 path の要素自体(テキスト)に当てはまる規則と、属性ごとに当てはまる規則を返す
+func (p *Policy) match(path []string) (*Rule, map[string]*Rule) {
+	var element *Rule
+	var attrs map[string]*Rule
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !rule.matches(path) {
+			continue
+		}
+		if rule.Attribute == "" {
+			if element == nil {
+				element = rule
+			}
+			continue
+		}
+		if attrs == nil {
+			attrs = make(map[string]*Rule)
+		}
+		if _, ok := attrs[rule.Attribute]; !ok {
+			attrs[rule.Attribute] = rule
+		}
+	}
+	return element, attrs
+}
+
+func (r *Rule) matches(path []string) bool {
+	segments := strings.Split(strings.Trim(r.Path, "/"), "/")
+	if len(segments) > len(path) {
+		return false
+	}
+	if strings.HasPrefix(r.Path, "/") && len(segments) != len(path) {
+		return false
+	}
+	tail := path[len(path)-len(segments):]
+	for i, segment := range segments {
+		if segment != "*" && segment != tail[i] {
+			return false
+		}
+	}
+	return true
+}
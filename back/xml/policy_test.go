@@ -0,0 +1,157 @@
+package xml
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePolicy(t *testing.T) {
+	yamlPolicy := []byte(`
+rules:
+  - path: given
+    action: blank
+  - path: /AnnotatedECG/effectiveTime/low
+    attribute: value
+    action: shift_date
+`)
+	jsonPolicy := []byte(`{"rules": [
+		{"path": "given", "action": "blank"},
+		{"path": "/AnnotatedECG/effectiveTime/low", "attribute": "value", "action": "shift_date"}
+	]}`)
+
+	for _, data := range [][]byte{yamlPolicy, jsonPolicy} {
+		policy, err := ParsePolicy(data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(policy.Rules) != 2 || policy.Rules[1].Attribute != "value" || policy.Rules[1].Action != ActionShiftDate {
+			t.Errorf("unexpected policy: %+v", policy)
+		}
+	}
+
+	invalid := [][]byte{
+		[]byte(`{"rules": [{"path": "given", "action": "scramble"}]}`),
+		[]byte(`{"rules": [{"action": "blank"}]}`),
+		[]byte(`rules: [`),
+	}
+	for _, data := range invalid {
+		if _, err := ParsePolicy(data); !errors.Is(err, ErrInvalidPolicy) {
+			t.Errorf("expected ErrInvalidPolicy for %s, got: %v", data, err)
+		}
+	}
+}
+
+func TestAnonymizeWithPolicy(t *testing.T) {
+	xmlData := []byte(`<AnnotatedECG xmlns="urn:hl7-org:v3">
+  <effectiveTime><low value="20070301102030.000+0900"/></effectiveTime>
+  <patientPatient>
+    <name><given>Taro</given><family>Yamada</family></name>
+    <administrativeGenderCode code="M" codeSystem="2.16.840.1.113883.5.1"/>
+    <telecom value="tel:000-0000"/>
+  </patientPatient>
+  <location><name>Ward 1</name></location>
+</AnnotatedECG>`)
+
+	policy := &Policy{Rules: []Rule{
+		{Path: "given", Action: ActionHash},
+		{Path: "family", Action: ActionConstant, Value: "X"},
+		{Path: "/AnnotatedECG/effectiveTime/low", Attribute: "value", Action: ActionShiftDate},
+		{Path: "administrativeGenderCode", Attribute: "codeSystem", Action: ActionRemove},
+		{Path: "telecom", Attribute: "value", Action: ActionBlank},
+		{Path: "location", Action: ActionRemove},
+	}}
+	opts := Options{PreserveFormat: true, Policy: policy, HashKey: []byte("secret"), DateShiftDays: -1}
+
+	anonymized, err := AnonymizeWithOptions(xmlData, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `<AnnotatedECG xmlns="urn:hl7-org:v3">
+  <effectiveTime><low value="20070228102030.000+0900"/></effectiveTime>
+  <patientPatient>
+    <name><given>` + hashValue([]byte("secret"), "Taro") + `</given><family>X</family></name>
+    <administrativeGenderCode code="M"/>
+    <telecom value=""/>
+  </patientPatient>
+  
+</AnnotatedECG>`
+	if string(anonymized) != expected {
+		t.Errorf("unexpected output\ngot:\n%s\nwant:\n%s", anonymized, expected)
+	}
+
+	// 書式を残さない場合も同じ規則が適用される
+	opts.PreserveFormat = false
+	anonymized, err = AnonymizeWithOptions(xmlData, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, s := range []string{"Taro", "Yamada", "Ward", "tel:", "codeSystem", "20070301"} {
+		if strings.Contains(string(anonymized), s) {
+			t.Errorf("%q remains in output: %s", s, anonymized)
+		}
+	}
+
+	// hash の規則には鍵が必要
+	opts.HashKey = nil
+	if _, err := AnonymizeWithOptions(xmlData, opts); !errors.Is(err, ErrNoHashKey) {
+		t.Errorf("expected ErrNoHashKey, got: %v", err)
+	}
+}
+
+func TestShiftDate(t *testing.T) {
+	tests := []struct {
+		value    string
+		days     int
+		expected string
+	}{
+		{"20070301", -1, "20070228"},
+		{"20071231235959.123-0500", 1, "20080101235959.123-0500"},
+		{"200703", 10, "200703"},
+		{"2007-03-01", 1, "2007-03-02"},
+		{"March 1, 2007", 1, "March 1, 2007"},
+		{"0.12", 1, "0.12"},
+	}
+	for _, tt := range tests {
+		if got := shiftDate(tt.value, tt.days); got != tt.expected {
+			t.Errorf("shiftDate(%q, %d) = %q, want: %q", tt.value, tt.days, got, tt.expected)
+		}
+	}
+}
+
+func TestDefaultPolicyShiftsDates(t *testing.T) {
+	xmlData := []byte(`<AnnotatedECG xmlns="urn:hl7-org:v3">
+  <effectiveTime><low value="20070301102030"/><high value="20070301102040"/></effectiveTime>
+  <patientPatient><id extension="12345"/><birthTime value="19841123"/></patientPatient>
+  <sequence><code code="TIME_ABSOLUTE"/><value><head value="20070301102030.000"/><increment value="0.002" unit="s"/></value></sequence>
+  <sequence><code code="TIME_RELATIVE"/><value><head value="0" unit="s"/></value></sequence>
+  <referenceRange><value><low value="0.12" unit="s"/><high value="0.2" unit="s"/></value></referenceRange>
+</AnnotatedECG>`)
+
+	anonymized, err := AnonymizeWithOptions(xmlData, Options{PreserveFormat: true, DateShiftDays: 30})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `<AnnotatedECG xmlns="urn:hl7-org:v3">
+  <effectiveTime><low value="20070331102030"/><high value="20070331102040"/></effectiveTime>
+  <patientPatient><id extension=""/><birthTime value="198411"/></patientPatient>
+  <sequence><code code="TIME_ABSOLUTE"/><value><head value="20070331102030.000"/><increment value="0.002" unit="s"/></value></sequence>
+  <sequence><code code="TIME_RELATIVE"/><value><head value="0" unit="s"/></value></sequence>
+  <referenceRange><value><low value="0.12" unit="s"/><high value="0.2" unit="s"/></value></referenceRange>
+</AnnotatedECG>`
+	if string(anonymized) != expected {
+		t.Errorf("unexpected output\ngot:\n%s\nwant:\n%s", anonymized, expected)
+	}
+}
+
+func TestSamplePolicy(t *testing.T) {
+	// setup のサンプルは既定の規則と同じにしておく
+	policy, err := LoadPolicy("../../setup/xml-policy.sample.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(policy, DefaultPolicy()) {
+		t.Errorf("sample policy differs from default policy\ngot:  %+v\nwant: %+v", policy.Rules, DefaultPolicy().Rules)
+	}
+}
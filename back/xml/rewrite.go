@@ -0,0 +1,119 @@
+package xml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+)
+
+// edit は元の文書の start から end までを value に置き換える書き換え
+type edit struct {
+	start, end int
+	value      []byte
+}
+
+// applyEdits は書き換えを適用した文書を返す (書き換えの範囲は重ならないものとする)
+func applyEdits(data []byte, edits []edit) []byte {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	var buf bytes.Buffer
+	pos := 0
+	for _, e := range edits {
+		buf.Write(data[pos:e.start])
+		buf.Write(e.value)
+		pos = e.end
+	}
+	buf.Write(data[pos:])
+	return buf.Bytes()
+}
+
+// attributeEdits は開始タグ raw (文書中の位置は offset) の属性を changes に従って書き換える edit を返す
+// encoding/xml は属性を文書中の順に返すので、changes の i 番目は raw の i 番目の属性に対応する
+func attributeEdits(raw []byte, offset int, changes []attrChange) ([]edit, error) {
+	var edits []edit
+	var spans []attrSpan
+	for i, change := range changes {
+		if !change.changed {
+			continue
+		}
+		if spans == nil {
+			var err error
+			if spans, err = attributeSpans(raw); err != nil {
+				return nil, err
+			}
+			if len(spans) != len(changes) {
+				return nil, fmt.Errorf("error locating attributes at offset %d", offset)
+			}
+		}
+
+		span := spans[i]
+		if change.remove {
+			// 前の空白から閉じ引用符までを取り除く
+			edits = append(edits, edit{start: offset + span.start, end: offset + span.valueEnd + 1})
+			continue
+		}
+		var value bytes.Buffer
+		if err := xml.EscapeText(&value, []byte(change.value)); err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit{
+			start: offset + span.valueStart,
+			end:   offset + span.valueEnd,
+			value: value.Bytes(),
+		})
+	}
+	return edits, nil
+}
+
+// attrSpan は開始タグ内の1つの属性の位置
+// start は属性名の前の空白の先頭、valueStart と valueEnd は引用符を除いた値の範囲
+type attrSpan struct {
+	start, valueStart, valueEnd int
+}
+
+// attributeSpans は開始タグ raw の各属性の位置を返す
+func attributeSpans(raw []byte) ([]attrSpan, error) {
+	i := bytes.IndexByte(raw, '<')
+	if i < 0 {
+		return nil, fmt.Errorf("error locating start tag")
+	}
+	i++
+	// 要素名を読み飛ばす
+	for i < len(raw) && !isSpace(raw[i]) && raw[i] != '>' && raw[i] != '/' {
+		i++
+	}
+
+	var spans []attrSpan
+	for {
+		start := i
+		for i < len(raw) && isSpace(raw[i]) {
+			i++
+		}
+		if i >= len(raw) || raw[i] == '>' || raw[i] == '/' {
+			return spans, nil
+		}
+		eq := bytes.IndexByte(raw[i:], '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("error locating attribute value")
+		}
+		i += eq + 1
+		for i < len(raw) && isSpace(raw[i]) {
+			i++
+		}
+		if i >= len(raw) || (raw[i] != '"' && raw[i] != '\'') {
+			return nil, fmt.Errorf("error locating attribute value")
+		}
+		quote := raw[i]
+		end := bytes.IndexByte(raw[i+1:], quote)
+		if end < 0 {
+			return nil, fmt.Errorf("error locating attribute value")
+		}
+		spans = append(spans, attrSpan{start: start, valueStart: i + 1, valueEnd: i + 1 + end})
+		i += end + 2
+	}
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n'
+}
@@ -5,78 +5,56 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
-	"time"
+	"strings"
 )
 
-func GetPersonalInfo(xmlData []byte) (string, string, string, string, error) {
-	var ecgID, patientID, name, birthtime string
-	decoder := xml.NewDecoder(bytes.NewReader(xmlData))
-	var inFamily, inPatientPatient, ecgIDisRecorded bool
+// Options は XML の匿名化の設定
+type Options struct {
+	// PreserveFormat が true の場合は対象の値だけを書き換え、
+	// 名前空間、XML宣言、処理命令、属性の順序、空白などは元の文書のまま残す
+	PreserveFormat bool
+	// Policy は HL7 aECG の文書に使う匿名化の規則 (nil の場合は既定の規則を使う)
+	// GE MUSE と Philips の文書は要素の名前が異なるため、常に形式ごとの既定の規則を使う
+	Policy *Policy
+	// HashKey は hash の規則に使う鍵
+	HashKey []byte
+	// DateShiftDays は shift_date の規則で日付をずらす日数
+	DateShiftDays int
+}
 
-	for {
-		token, err := decoder.Token()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return "", "", "", "", fmt.Errorf("error decoding token: %w", err)
-		}
+func Anonymize(xmlData []byte) ([]byte, error) {
+	return AnonymizeWithOptions(xmlData, Options{})
+}
 
-		switch tok := token.(type) {
-		case xml.StartElement:
-			switch tok.Name.Local {
-			case "family":
-				inFamily = true
-			case "birthTime":
-				for _, attr := range tok.Attr {
-					if attr.Name.Local == "value" {
-						birthtime = attr.Value
-					}
-				}
-			case "patientPatient":
-				inPatientPatient = true
-			case "id":
-				if inPatientPatient {
-					for _, attr := range tok.Attr {
-						if attr.Name.Local == "extension" {
-							patientID = attr.Value
-							break
-						}
-					}
-				} else if !ecgIDisRecorded {
-					for _, attr := range tok.Attr {
-						if attr.Name.Local == "extension" {
-							ecgID = attr.Value
-							ecgIDisRecorded = true
-							break
-						}
-					}
-				}
-			}
-		case xml.EndElement:
-			if tok.Name.Local == "family" {
-				inFamily = false
-			} else if tok.Name.Local == "patientPatient" {
-				inPatientPatient = false
-			}
-		case xml.CharData:
-			if inFamily {
-				name += string(tok)
-			}
-		}
+func AnonymizeWithOptions(xmlData []byte, opts Options) ([]byte, error) {
+	dialect, err := DetectDialect(xmlData)
+	if err != nil {
+		return nil, err
+	}
+	policy := dialect.Policy()
+	if opts.Policy != nil && dialect.isHL7() {
+		policy = opts.Policy
 	}
 
-	return ecgID, patientID, name, birthtime, nil
-}
+	// 書き換えの位置を数えやすいように UTF-8 に変換してから読む
+	xmlData, enc, err := toUTF8(xmlData)
+	if err != nil {
+		return nil, err
+	}
 
-func Anonymize(xmlData []byte) ([]byte, error) {
 	var buffer bytes.Buffer
 	decoder := xml.NewDecoder(bytes.NewReader(xmlData))
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
 	encoder := xml.NewEncoder(&buffer)
 
-	var inFamily, inPatientPatient, ecgIDisRecorded bool
+	var path []string
+	var textRules []*Rule // 開いている要素ごとの、直下のテキストに適用する規則
+	var edits []edit
 
 	for {
+		offset := int(decoder.InputOffset())
 		token, err := decoder.Token()
 		if err == io.EOF {
 			break
@@ -84,91 +62,170 @@ func Anonymize(xmlData []byte) ([]byte, error) {
 		if err != nil {
 			return nil, fmt.Errorf("error decoding token: %w", err)
 		}
+		raw := xmlData[offset:decoder.InputOffset()]
 
 		switch tok := token.(type) {
 		case xml.StartElement:
-			inFamily, inPatientPatient, ecgIDisRecorded = handleStartElement(tok, encoder, inFamily, inPatientPatient, ecgIDisRecorded)
+			path = append(path, tok.Name.Local)
+			rule, attrRules := policy.match(path)
+			if rule != nil && rule.Action == ActionRemove {
+				// 要素を子孫ごと取り除く
+				if err := decoder.Skip(); err != nil {
+					return nil, fmt.Errorf("error decoding token: %w", err)
+				}
+				path = path[:len(path)-1]
+				if opts.PreserveFormat {
+					edits = append(edits, edit{start: offset, end: int(decoder.InputOffset())})
+				}
+				continue
+			}
+			if rule != nil && rule.Action == ActionKeep {
+				rule = nil
+			}
+			textRules = append(textRules, rule)
+
+			changes, err := opts.attributeChanges(tok.Attr, attrRules)
+			if err != nil {
+				return nil, err
+			}
+			if opts.PreserveFormat {
+				attrEdits, err := attributeEdits(raw, offset, changes)
+				if err != nil {
+					return nil, err
+				}
+				edits = append(edits, attrEdits...)
+				continue
+			}
+			tok = tok.Copy()
+			tok.Attr = applyAttributeChanges(tok.Attr, changes)
+			tok.Name.Space = ""
+			tok.Attr = removeNamespace(tok.Attr)
+			encoder.EncodeToken(tok)
 		case xml.EndElement:
-			inFamily, inPatientPatient = handleEndElement(tok, encoder, inFamily, inPatientPatient)
+			path = path[:len(path)-1]
+			textRules = textRules[:len(textRules)-1]
+			if !opts.PreserveFormat {
+				tok.Name.Space = ""
+				encoder.EncodeToken(tok)
+			}
 		case xml.CharData:
-			handleCharData(tok, encoder, inFamily)
+			text := string(tok)
+			var rule *Rule
+			if len(textRules) > 0 {
+				rule = textRules[len(textRules)-1]
+			}
+			if rule != nil && strings.TrimSpace(text) != "" {
+				if text, err = opts.replaceText(rule, text); err != nil {
+					return nil, err
+				}
+				if opts.PreserveFormat {
+					var value bytes.Buffer
+					if err := xml.EscapeText(&value, []byte(text)); err != nil {
+						return nil, err
+					}
+					edits = append(edits, edit{start: offset, end: offset + len(raw), value: value.Bytes()})
+					continue
+				}
+			}
+			if !opts.PreserveFormat {
+				encoder.EncodeToken(xml.CharData(text))
+			}
 		default:
-			encoder.EncodeToken(tok)
+			if !opts.PreserveFormat {
+				encoder.EncodeToken(tok)
+			}
 		}
 	}
 
+	if opts.PreserveFormat {
+		return fromUTF8(applyEdits(xmlData, edits), enc)
+	}
+
 	if err := encoder.Flush(); err != nil {
 		return nil, fmt.Errorf("error flushing encoder: %w", err)
 	}
 
-	return buffer.Bytes(), nil
+	return fromUTF8(buffer.Bytes(), enc)
 }
 
-func handleStartElement(tok xml.StartElement, encoder *xml.Encoder, inFamily, inPatientPatient, ecgIDisRecorded bool) (bool, bool, bool) {
-	switch tok.Name.Local {
-	case "family":
-		inFamily = true
-	case "birthTime":
-		tok.Attr = removeDayFromBirthDate(tok.Attr)
-	case "patientPatient":
-		inPatientPatient = true
-	case "id":
-		if inPatientPatient {
-			tok.Attr = modifyAttribute(tok.Attr, "extension", "")
-		} else if !ecgIDisRecorded {
-			tok.Attr = modifyAttribute(tok.Attr, "extension", "")
-			ecgIDisRecorded = true
+// apply は規則に従って値を置き換える
+func (o *Options) apply(rule *Rule, value string) (string, error) {
+	switch rule.Action {
+	case ActionKeep:
+		return value, nil
+	case ActionRemove, ActionBlank:
+		return "", nil
+	case ActionHash:
+		if value == "" {
+			return "", nil
 		}
+		if len(o.HashKey) == 0 {
+			return "", ErrNoHashKey
+		}
+		return hashValue(o.HashKey, value), nil
+	case ActionGeneralizeDate:
+		return generalizeDate(value), nil
+	case ActionShiftDate:
+		return shiftDate(value, o.DateShiftDays), nil
+	case ActionConstant:
+		return rule.Value, nil
 	}
-
-	tok.Name.Space = ""
-	tok.Attr = removeNamespace(tok.Attr)
-	encoder.EncodeToken(tok)
-
-	return inFamily, inPatientPatient, ecgIDisRecorded
+	return "", fmt.Errorf("%w: unknown action %q", ErrInvalidPolicy, rule.Action)
 }
 
-func handleEndElement(tok xml.EndElement, encoder *xml.Encoder, inFamily, inPatientPatient bool) (bool, bool) {
-	if tok.Name.Local == "family" {
-		inFamily = false
-	} else if tok.Name.Local == "patientPatient" {
-		inPatientPatient = false
+// replaceText はテキストの前後の空白を残して中身を置き換える
+func (o *Options) replaceText(rule *Rule, text string) (string, error) {
+	trimmed := strings.TrimSpace(text)
+	i := strings.Index(text, trimmed)
+	value, err := o.apply(rule, trimmed)
+	if err != nil {
+		return "", err
 	}
-
-	tok.Name.Space = ""
-	encoder.EncodeToken(tok)
-
-	return inFamily, inPatientPatient
+	return text[:i] + value + text[i+len(trimmed):], nil
 }
 
-func handleCharData(tok xml.CharData, encoder *xml.Encoder, inFamily bool) {
-	if inFamily {
-		tok = []byte("")
+// attributeChanges は属性ごとの書き換えを返す (書き換えがなければ nil)
+func (o *Options) attributeChanges(attrs []xml.Attr, rules map[string]*Rule) ([]attrChange, error) {
+	if len(rules) == 0 {
+		return nil, nil
 	}
-	encoder.EncodeToken(tok)
-}
-
-func removeDayFromBirthDate(attrs []xml.Attr) []xml.Attr {
-	var newAttrs []xml.Attr
-	for _, attr := range attrs {
-		if attr.Name.Local == "value" {
-			birth, err := time.Parse("20060102150405", attr.Value)
-			if err != nil {
-				attr.Value = fmt.Sprintln("Error:", err)
-			} else {
-				attr.Value = fmt.Sprintf("%d/%d", birth.Year(), birth.Month())
-			}
+	changes := make([]attrChange, len(attrs))
+	for i, attr := range attrs {
+		rule, ok := rules[attr.Name.Local]
+		if !ok {
+			continue
+		}
+		value, err := o.apply(rule, attr.Value)
+		if err != nil {
+			return nil, err
+		}
+		changes[i] = attrChange{
+			changed: value != attr.Value || rule.Action == ActionRemove,
+			remove:  rule.Action == ActionRemove,
+			value:   value,
 		}
-		newAttrs = append(newAttrs, attr)
 	}
-	return newAttrs
+	return changes, nil
+}
+
+// attrChange は1つの属性の書き換え
+type attrChange struct {
+	changed bool
+	remove  bool
+	value   string
 }
 
-func modifyAttribute(attrs []xml.Attr, attrName, newValue string) []xml.Attr {
+func applyAttributeChanges(attrs []xml.Attr, changes []attrChange) []xml.Attr {
+	if changes == nil {
+		return attrs
+	}
 	var newAttrs []xml.Attr
-	for _, attr := range attrs {
-		if attr.Name.Local == attrName {
-			attr.Value = newValue
+	for i, attr := range attrs {
+		if changes[i].remove {
+			continue
+		}
+		if changes[i].changed {
+			attr.Value = changes[i].value
 		}
 		newAttrs = append(newAttrs, attr)
 	}
@@ -1,28 +1,27 @@
 package xml
 
 import (
+	"errors"
+	"strings"
 	"testing"
 )
 
 func TestGetPersonalInfo(t *testing.T) {
 	// テスト用のXMLデータを定義
 	xmlData := []byte(`
-		<id extention="hoge">
-        <patientPatient>
-            <id extention="12345"/>
-            <family>Smith</family>
-            <birthTime value="2000-01-01"/>
-        </patientPatient>
-    `)
-
-	// 期待される出力
-	expectedEcgID := "hoge"
-	expectedPatientID := "12345"
-	expectedName := "Smith"
-	expectedBirthtime := "2000-01-01"
+		<AnnotatedECG>
+			<id extension="hoge"/>
+			<effectiveTime><low value="20070301102030"/></effectiveTime>
+			<patientPatient>
+				<id extension="12345"/>
+				<family>Smith</family>
+				<birthTime value="2000-01-01"/>
+			</patientPatient>
+		</AnnotatedECG>
+	`)
 
 	// 関数を呼び出し
-	ecgID, patientID, name, birthtime, err := GetPersonalInfo(xmlData)
+	info, err := GetPersonalInfo(xmlData)
 
 	// エラーチェック
 	if err != nil {
@@ -30,16 +29,118 @@ func TestGetPersonalInfo(t *testing.T) {
 	}
 
 	// 結果の確認
-	if ecgID != expectedEcgID {
-		t.Errorf("unexpected ecgID, got: %s, want: %s", ecgID, expectedEcgID)
+	expected := PersonalInfo{
+		Dialect:         DialectHL7,
+		ECGID:           "hoge",
+		PatientID:       "12345",
+		Name:            "Smith",
+		BirthTime:       "2000-01-01",
+		AcquisitionTime: "20070301102030",
 	}
-	if patientID != expectedPatientID {
-		t.Errorf("unexpected patientID, got: %s, want: %s", patientID, expectedPatientID)
+	if info.Dialect != expected.Dialect || info.ECGID != expected.ECGID || info.PatientID != expected.PatientID ||
+		info.Name != expected.Name || info.BirthTime != expected.BirthTime || info.AcquisitionTime != expected.AcquisitionTime {
+		t.Errorf("unexpected personal info, got: %+v, want: %+v", info, expected)
 	}
-	if name != expectedName {
-		t.Errorf("unexpected name, got: %s, want: %s", name, expectedName)
+	if !info.Found(FieldPatientID) || info.Found(FieldSex) || info.Found(FieldDevice) {
+		t.Errorf("unexpected found fields: %+v", info)
 	}
-	if birthtime != expectedBirthtime {
-		t.Errorf("unexpected birthtime, got: %s, want: %s", birthtime, expectedBirthtime)
+}
+
+func TestGetPersonalInfoNoPatientID(t *testing.T) {
+	tests := []string{
+		`<AnnotatedECG><patientPatient><family>Smith</family></patientPatient></AnnotatedECG>`,
+		`<AnnotatedECG><patientPatient><id extension=""/></patientPatient></AnnotatedECG>`,
+	}
+	for _, xmlData := range tests {
+		info, err := GetPersonalInfo([]byte(xmlData))
+		if !errors.Is(err, ErrNoPatientID) {
+			t.Errorf("expected ErrNoPatientID, got: %v", err)
+		}
+		if info == nil {
+			t.Fatalf("expected partial info")
+		}
+	}
+	info, _ := GetPersonalInfo([]byte(tests[1]))
+	if !info.Found(FieldPatientID) {
+		t.Errorf("empty patient ID should be reported as found")
+	}
+}
+
+func TestAnonymizePreserveFormat(t *testing.T) {
+	xmlData := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<?xml-stylesheet type="text/xsl" href="aecg.xsl"?>
+<AnnotatedECG xmlns="urn:hl7-org:v3" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" classCode="OBS">
+  <id root="1.2.3" extension='ecg-001'/>
+  <subject>
+    <patient>
+      <patientPatient>
+        <id root="1.2.3.4" extension="12345"/>
+        <name><family>Smith &amp; Sons</family></name>
+        <birthTime value="19841123000000"/>
+      </patientPatient>
+    </patient>
+  </subject>
+</AnnotatedECG>`)
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<?xml-stylesheet type="text/xsl" href="aecg.xsl"?>
+<AnnotatedECG xmlns="urn:hl7-org:v3" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" classCode="OBS">
+  <id root="1.2.3" extension=''/>
+  <subject>
+    <patient>
+      <patientPatient>
+        <id root="1.2.3.4" extension=""/>
+        <name><family></family></name>
+        <birthTime value="198411"/>
+      </patientPatient>
+    </patient>
+  </subject>
+</AnnotatedECG>`
+
+	anonymized, err := AnonymizeWithOptions(xmlData, Options{PreserveFormat: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(anonymized) != expected {
+		t.Errorf("unexpected output\ngot:\n%s\nwant:\n%s", anonymized, expected)
+	}
+}
+
+func TestAnonymizeSafeHarbor(t *testing.T) {
+	xmlData := []byte(`<AnnotatedECG xmlns="urn:hl7-org:v3">
+  <id extension="ecg-001"/>
+  <subject>
+    <patient>
+      <patientPatient>
+        <id extension="12345"/>
+        <name><prefix>Mr.</prefix><given>Taro</given><family>Yamada</family><suffix>Jr.</suffix></name>
+        <addr><streetAddressLine>1-2-3 Chuo</streetAddressLine><city>Kobe</city></addr>
+        <telecom value="tel:078-000-0000"/>
+        <birthTime value="19841123"/>
+      </patientPatient>
+    </patient>
+  </subject>
+  <author>
+    <assignedEntity>
+      <id extension="doctor-9"/>
+      <assignedPerson><name>Hanako Suzuki</name></assignedPerson>
+      <representedOrganization><name>Kobe Clinic</name><telecom value="mailto:info@example.com"/></representedOrganization>
+    </assignedEntity>
+  </author>
+</AnnotatedECG>`)
+
+	for _, preserve := range []bool{true, false} {
+		anonymized, err := AnonymizeWithOptions(xmlData, Options{PreserveFormat: preserve})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, s := range []string{"ecg-001", "12345", "Mr.", "Taro", "Yamada", "Jr.", "Chuo", "Kobe", "tel:", "doctor-9", "Hanako", "info@"} {
+			if strings.Contains(string(anonymized), s) {
+				t.Errorf("%q remains in output (preserve: %v): %s", s, preserve, anonymized)
+			}
+		}
+		if !strings.Contains(string(anonymized), `<birthTime value="198411"`) {
+			t.Errorf("birthTime is not generalized (preserve: %v): %s", preserve, anonymized)
+		}
 	}
 }
@@ -9,6 +9,9 @@ import {
   Typography,  
   Paper,
   Stack,  
+  Checkbox,
+  FormControlLabel,
+  FormGroup,
 } from '@mui/material';
 import { uploadFiles } from '@/lib/uploadFiles';
 
@@ -24,6 +27,7 @@ const Form = () => {
 
   const [files, setFiles] = useState<File[]>([]);
   const [uploading, setUploading] = useState(false);  
+  const [outputFormats, setOutputFormats] = useState<string[]>([]);
   const router = useRouter();
   const fileInputRef = useRef<HTMLInputElement>(null);
 
@@ -34,11 +38,18 @@ const Form = () => {
     }
   };
 
+  // 出力形式のチェックボックスのハンドラー
+  const handleFormatChange = (format: string) => (e: ChangeEvent<HTMLInputElement>) => {
+    setOutputFormats(prev =>
+      e.target.checked ? [...prev, format] : prev.filter(f => f !== format)
+    );
+  };
+
   // フォーム送信時のハンドラー
   const handleFormSubmit = async (data: FormValuesType) => {
     if (files.length === 0) return;
     setUploading(true);
-    await uploadFiles(data.password, data.passwordConfirmation, files);
+    await uploadFiles(data.password, data.passwordConfirmation, files, outputFormats);
     setUploading(false);
   };
 
@@ -69,6 +80,17 @@ const Form = () => {
                 value === passwordValue || 'パスワードが一致しません'
             })}
           />        
+
+          <FormGroup>
+            <FormControlLabel
+              control={<Checkbox checked={outputFormats.includes('wfdb')} onChange={handleFormatChange('wfdb')} />}
+              label="WFDB形式(.hea/.dat)も出力する"
+            />
+            <FormControlLabel
+              control={<Checkbox checked={outputFormats.includes('edf')} onChange={handleFormatChange('edf')} />}
+              label="EDF+形式(.edf)も出力する"
+            />
+          </FormGroup>
         </Stack>            
 
         <input
@@ -3,7 +3,8 @@ import JSZip from "jszip";
 export async function uploadFiles(
     password: string,
     passwordConfirmation: string,
-    files: File[]
+    files: File[],
+    outputFormats: string[] = []
 ) {
     return new Promise<void>((resolve, reject) => {
         const ws = new WebSocket("ws://localhost:8080/upload");
@@ -15,6 +16,7 @@ export async function uploadFiles(
                     type: "credentials",
                     password,
                     passwordConfirmation,
+                    outputFormats,
                 })
             );
         };
@@ -69,6 +71,24 @@ export async function uploadFiles(
 }
 
 
+// 匿名化後も識別子が残っていたため出力されなかったファイルの位置
+type ResidualPIIFinding = {
+    file: string;
+    source?: string;
+    offset: number;
+    kind: string;
+    encoding: string;
+};
+
+function reportResidualPII(findings?: ResidualPIIFinding[]) {
+    if (!findings || findings.length === 0) return;
+    const lines = findings.map(
+        (f) => `${f.source ?? f.file}: ${f.kind} (${f.file} の ${f.offset} バイト目, ${f.encoding})`
+    );
+    console.warn("Residual PII:", findings);
+    alert(`匿名化後も個人情報が残っていたため、次のファイルを含む処理単位は出力していません\n${lines.join("\n")}`);
+}
+
 export function downloadZip(ws: WebSocket) {
     return async (event: MessageEvent) => {
     const message = event.data;
@@ -78,6 +98,7 @@ export function downloadZip(ws: WebSocket) {
             const metaData = JSON.parse(message);
             if (metaData.fileName && metaData.fileType) {
             console.log(`Receiving file: ${metaData.fileName}`);
+            reportResidualPII(metaData.residualPII);
             // メタデータを受信した場合、次のバイナリメッセージを待つ
             ws.onmessage = (event) => {
                 if (typeof event.data === 'object') {